@@ -12,7 +12,7 @@ func (tc *TorrentClient) SnubberChecker() {
 
 	go func() {
 		for range snubbedTimer.C {
-			for _, peer := range tc.Peers {
+			for _, peer := range tc.PeerList() {
 				peer.mu.Lock()
 
 				if peer.Snubbed && time.Now().After(peer.SnubbedUntil) {
@@ -35,12 +35,15 @@ func (tc *TorrentClient) UpdateDownloadRateOfPeers() {
 	for range downloadRateTimer.C {
 		select {
 		case <-downloadRateTimer.C:
-			for _, peer := range tc.Peers {
+			for _, peer := range tc.PeerList() {
+				// RecordDownload adds to BytesDownloaded from PeerLoop
+				peer.mu.Lock()
 				duration := time.Now().Sub(peer.LastCheckedTime)
 				rate := float64(peer.BytesDownloaded) / duration.Seconds()
 				peer.DownloadRate = int(rate)
 				peer.BytesDownloaded = 0
 				peer.LastCheckedTime = time.Now()
+				peer.mu.Unlock()
 			}
 		}
 	}
@@ -53,7 +56,7 @@ func (tc *TorrentClient) RunCheckLoop() {
 	for {
 		select {
 		case <-ticker.C:
-			if tc.IsSeeder {
+			if tc.seeding() {
 				tc.runSeederChoke()
 			} else {
 				tc.runLeecherChoke()
@@ -64,52 +67,76 @@ func (tc *TorrentClient) RunCheckLoop() {
 	}
 }
 
-func (tc *TorrentClient) runSeederChoke() {
-	interestedPeers := []*Peer{}
-	for _, peer := range tc.Peers {
-		if peer.Interested && !peer.Snubbed {
-			interestedPeers = append(interestedPeers, peer)
+// seeding reports IsSeeder, which checkDone sets once the download is complete.
+func (tc *TorrentClient) seeding() bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.IsSeeder
+}
+
+// chokeCandidate is what the choker ranks a peer by, copied under peer.mu
+// since PeerLoop keeps changing it.
+type chokeCandidate struct {
+	peer           *Peer
+	downloadRate   int
+	lastUnchokedAt time.Time
+}
+
+// chokeCandidates are the interested peers that aren't snubbed, with
+// chokedOnly just the ones we choke.
+func (tc *TorrentClient) chokeCandidates(chokedOnly bool) []chokeCandidate {
+	var candidates []chokeCandidate
+	for _, peer := range tc.PeerList() {
+		peer.mu.Lock()
+		if peer.Interested && !peer.Snubbed && (!chokedOnly || peer.Choked) {
+			candidates = append(candidates, chokeCandidate{peer: peer, downloadRate: peer.DownloadRate, lastUnchokedAt: peer.LastUnchokedAt})
 		}
+		peer.mu.Unlock()
 	}
+	return candidates
+}
+
+func (tc *TorrentClient) unchoke(peer *Peer) {
+	tc.SetChoked(peer, false)
+	peer.mu.Lock()
+	peer.LastUnchokedAt = time.Now()
+	peer.mu.Unlock()
+}
+
+func (tc *TorrentClient) runSeederChoke() {
+	interestedPeers := tc.chokeCandidates(false)
 
 	sort.Slice(interestedPeers, func(i, j int) bool {
-		return interestedPeers[i].LastUnchokedAt.Before(interestedPeers[j].LastUnchokedAt)
+		return interestedPeers[i].lastUnchokedAt.Before(interestedPeers[j].lastUnchokedAt)
 	})
 
-	for i, peer := range interestedPeers {
-		if i < 3 {
-			peer.Choked = false
-			peer.LastUnchokedAt = time.Now()
-		} else {
-			peer.Choked = true
-		}
+	// pick the random extra up front so it isn't choked and unchoked in the same round
+	optimistic := -1
+	if len(interestedPeers) > 3 {
+		optimistic = rand.Intn(len(interestedPeers)-3) + 3
 	}
 
-	if len(interestedPeers) > 3 {
-		index := rand.Intn(len(interestedPeers)-3) + 3
-		interestedPeers[index].Choked = false
-		interestedPeers[index].LastUnchokedAt = time.Now()
+	for i, c := range interestedPeers {
+		if i < 3 || i == optimistic {
+			tc.unchoke(c.peer)
+		} else {
+			tc.SetChoked(c.peer, true)
+		}
 	}
 }
 
 func (tc *TorrentClient) runLeecherChoke() {
-	interestedPeers := []*Peer{}
-	for _, peer := range tc.Peers {
-		if peer.Interested && !peer.Snubbed {
-			interestedPeers = append(interestedPeers, peer)
-		}
-	}
+	interestedPeers := tc.chokeCandidates(false)
 
 	sort.Slice(interestedPeers, func(i, j int) bool {
-		return interestedPeers[i].DownloadRate > interestedPeers[j].DownloadRate
+		return interestedPeers[i].downloadRate > interestedPeers[j].downloadRate
 	})
 
-	for i, peer := range interestedPeers {
+	for i, c := range interestedPeers {
 		if i < 3 {
-			peer.Choked = false
-			peer.LastUnchokedAt = time.Now()
+			tc.unchoke(c.peer)
 		} else {
-			peer.Choked = true
+			tc.SetChoked(c.peer, true)
 		}
 	}
 }
@@ -117,12 +144,7 @@ func (tc *TorrentClient) runLeecherChoke() {
 func (tc *TorrentClient) runOptimisticLeecher() {
 	fmt.Println("🎲 [Leecher] Running optimistic unchoke...")
 
-	chokedInterestedPeers := []*Peer{}
-	for _, peer := range tc.Peers {
-		if peer.Interested && peer.Choked && !peer.Snubbed {
-			chokedInterestedPeers = append(chokedInterestedPeers, peer)
-		}
-	}
+	chokedInterestedPeers := tc.chokeCandidates(true)
 
 	if len(chokedInterestedPeers) == 0 {
 		fmt.Println("😢 No choked + interested peers found for optimistic unchoke.")
@@ -130,9 +152,8 @@ func (tc *TorrentClient) runOptimisticLeecher() {
 	}
 
 	randomIndex := rand.Intn(len(chokedInterestedPeers))
-	selectedPeer := chokedInterestedPeers[randomIndex]
+	selectedPeer := chokedInterestedPeers[randomIndex].peer
 
-	selectedPeer.Choked = false
-	selectedPeer.LastUnchokedAt = time.Now()
+	tc.unchoke(selectedPeer)
 	fmt.Printf(" Optimistically unchoked peer: %s\n", selectedPeer.IP)
 }
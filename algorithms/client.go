@@ -1,10 +1,15 @@
 package algorithms
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
+	"torrent-client/storage"
 )
 
 type TorrentClient struct {
+	InfoHash                     [20]byte
+	PeerID                       string
 	Peers                        map[string]*Peer
 	IsSeeder                     bool
 	UnchokeInterval              time.Duration
@@ -19,4 +24,44 @@ type TorrentClient struct {
 	Downloading  map[int]bool
 	PieceHashMap map[int][]byte
 	Strategy     string // "rarest", "random", "strict", "endgame"
+	// Picker overrides the automatic piece selection when set
+	Picker      PiecePicker
+	PieceLength int64
+	TotalLength int64
+	// PipelineDepth is how many block requests are kept in flight per peer
+	PipelineDepth int
+	// Storage receives every verified piece, nothing is written when it is nil
+	Storage storage.Storage
+	// OnPieceVerified is called with the data of every piece that passed the hash check
+	OnPieceVerified func(index int, data []byte)
+	// OnEndgameChange is called when endgame mode starts (true) or ends (false)
+	OnEndgameChange func(active bool)
+	// Extensions handles the extension protocol, without it the extension
+	// bit is left clear in our handshake
+	Extensions ExtensionHandler
+
+	mu         sync.Mutex
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	inProgress map[int]*pieceProgress
+	endgame    bool
+	// endgame changes waiting for OnEndgameChange, delivered in order by
+	// one goroutine at a time
+	endgameEvents    []bool
+	endgameNotifying bool
+	done             chan struct{}
+	doneOnce         sync.Once
+	// metadata is false for a torrent started from a magnet link until
+	// InitPieces gets the piece hashes
+	metadata bool
+	// selected are the pieces to download, nil means all of them
+	selected []bool
+}
+
+// HasMetadata reports whether the piece hashes are known, a torrent started
+// from a magnet link has none until the info dictionary has been fetched.
+func (tc *TorrentClient) HasMetadata() bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.metadata
 }
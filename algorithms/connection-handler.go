@@ -1,7 +1,6 @@
 package algorithms
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -16,71 +15,97 @@ func (tc *TorrentClient) ConnectToPeer(peer *Peer, address string, infoHash [20]
 	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
 	if err != nil {
 		log.Printf("❌ Failed to connect to peer %s: %v", address, err)
+		tc.forgetPeer(peer)
 		return
 	}
 
+	// the peer is already in tc.Peers, broadcasts may look at it any time
+	peer.writeMu.Lock()
 	peer.Conn = conn
+	peer.writeMu.Unlock()
 	log.Printf("🔗 Connected from %s to peer: %s", peerID, address)
 
-	err = tc.PerformHandshake(conn, infoHash, peerID)
+	hs, err := tc.PerformHandshake(conn, infoHash, peerID)
 	if err != nil {
 		log.Printf("❌ Handshake failed with %s: %v", address, err)
 		conn.Close()
+		tc.forgetPeer(peer)
 		return
 	}
 
-	peer.HandshakeDone = true
+	peer.mu.Lock()
+	peer.ID = hs.PeerID
+	peer.Reserved = hs.Reserved
+	peer.mu.Unlock()
 
 	// Start message loop
 	wg.Add(1)
 	go tc.PeerLoop(conn, peer, wg)
 }
 
-func (tc *TorrentClient) PerformHandshake(conn net.Conn, infoHash [20]byte, peerID string) error {
-	// Construct the handshake
-
-	buf := make([]byte, 68)
-
-	// first  I have to intiate the memory for protocol
-	buf[0] = 19
-	copy(buf[1:], "BitTorrent protocol")
-
-	copy(buf[28:], infoHash[:])
-	copy(buf[48:], []byte(peerID))
-
-	_, err := conn.Write(buf)
-
+func (tc *TorrentClient) PerformHandshake(conn net.Conn, infoHash [20]byte, peerID string) (*Handshake, error) {
+	err := WriteHandshake(conn, &Handshake{Reserved: tc.reserved(), InfoHash: infoHash, PeerID: peerIDBytes(peerID)})
 	if err != nil {
-		return fmt.Errorf("failed to send handshake: %w", err)
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
 	}
 
-	resp := make([]byte, 68)
-
 	// now I have to read the response and write in to memory and check the info hash
-	_, err = io.ReadFull(conn, resp)
-
+	resp, err := ReadHandshake(conn)
 	if err != nil {
-		return fmt.Errorf("failed to read handshake: %w", err)
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
 	}
 
-	if !bytes.Equal(resp[28:48], infoHash[:]) {
-		return fmt.Errorf("info hash mismatch")
+	if resp.InfoHash != infoHash {
+		return nil, fmt.Errorf("info hash mismatch")
+	}
+	if resp.PeerID == peerIDBytes(peerID) {
+		return nil, fmt.Errorf("connected to ourselves")
 	}
 
 	log.Println("🤝 Handshake successful")
 
-	return nil
+	return resp, nil
 }
+
 func (tc *TorrentClient) PeerLoop(conn net.Conn, peer *Peer, wg *sync.WaitGroup) {
 	defer wg.Done()
-
-	// Send INTERESTED once (you may enhance with bitfield logic later)
-	_, err := conn.Write(InterestedMessage())
-	if err != nil {
-		log.Printf("❌ Failed to send interested: %v", err)
+	defer tc.forgetPeer(peer)
+	defer conn.Close()
+	defer tc.dropPeerRequests(peer)
+	defer tc.RemovePeerAvailability(peer)
+
+	// every connection starts out choked in both directions
+	peer.mu.Lock()
+	peer.PeerChoking = true
+	peer.Choked = true
+	peer.Interested = false
+	peer.AmInterested = false
+	peer.allowedFast = nil
+	peer.allowedFastSent = nil
+	peer.suggested = nil
+	peer.mu.Unlock()
+	defer peer.disconnect()
+
+	stopUploads := make(chan struct{})
+	defer close(stopUploads)
+	go tc.serveUploads(peer, stopUploads)
+
+	// tell the peer what we have, interest follows once we know what it has
+	if err := tc.sendBitfield(peer); err != nil {
+		log.Printf("❌ Failed to send bitfield: %v", err)
+		return
+	}
+	if err := tc.sendAllowedFast(peer); err != nil {
+		log.Printf("❌ Failed to send allowed fast set: %v", err)
 		return
 	}
-	log.Println("📨 Sent INTERESTED to peer")
+	if tc.usesExtensions(peer) {
+		defer tc.Extensions.PeerDisconnected(peer)
+		if err := tc.Extensions.PeerConnected(peer); err != nil {
+			log.Printf("❌ Failed to send extended handshake: %v", err)
+			return
+		}
+	}
 
 	// Start loop to listen for messages
 	for {
@@ -90,38 +115,108 @@ func (tc *TorrentClient) PeerLoop(conn net.Conn, peer *Peer, wg *sync.WaitGroup)
 			return
 		}
 
+		if msg.IsKeepAlive() {
+			continue
+		}
+		if err := ValidateMessage(&msg); err != nil {
+			log.Printf("❌ Invalid message from peer: %v", err)
+			return
+		}
+
 		switch msg.ID {
-		case 0: // CHOKE
+		case MsgChoke:
 			log.Println("🚫 Peer choked us")
-			peer.Choked = true
+			tc.handleChoke(peer)
 
-		case 1: // UNCHOKE
+		case MsgUnchoke:
 			log.Println("✅ Peer unchoked us")
-			peer.Choked = false
-
-		case 4: // HAVE
-			// update peer.Bitfield[msg.Payload[0]] = true
-			log.Println("📦 Peer sent HAVE (implement logic here)")
-
-		case 5: // BITFIELD
-			peer.Bitfield = ParseBitfield(msg.Payload)
-			log.Printf("📊 Received bitfield: %v", peer.Bitfield)
-
-		case 7: // PIECE
-			log.Println("📥 Received PIECE (implement logic here)")
+			peer.mu.Lock()
+			peer.PeerChoking = false
+			peer.mu.Unlock()
+
+		case MsgInterested:
+			peer.mu.Lock()
+			peer.Interested = true
+			peer.mu.Unlock()
+
+		case MsgNotInterested:
+			peer.mu.Lock()
+			peer.Interested = false
+			peer.mu.Unlock()
+
+		case MsgHave:
+			index, _ := ParseHave(&msg)
+			if tc.deferPeerState(peer, nil, index) {
+				break
+			}
+			if err := tc.PeerHasPiece(peer, index); err != nil {
+				log.Printf("❌ Bad HAVE from peer: %v", err)
+				return
+			}
+			err = tc.updateInterest(peer)
+
+		case MsgBitfield:
+			if tc.deferPeerState(peer, msg.Payload, -1) {
+				break
+			}
+			bits, err := ParseBitfield(msg.Payload, tc.TotalPieces)
+			if err != nil {
+				log.Printf("❌ Bad BITFIELD from peer: %v", err)
+				return
+			}
+			tc.SetPeerBitfield(peer, bits)
+			log.Printf("📊 Received bitfield with %d pieces", countSet(bits))
+			if err := tc.updateInterest(peer); err != nil {
+				log.Printf("❌ Failed to send interest: %v", err)
+				return
+			}
+
+		case MsgRequest:
+			tc.handleRequest(peer, &msg)
+
+		case MsgCancel:
+			tc.handleCancel(peer, &msg)
+
+		case MsgPiece:
+			if err := tc.handleBlock(peer, &msg); err != nil {
+				log.Printf("❌ Bad PIECE from peer: %v", err)
+				return
+			}
+
+		case MsgHaveAll, MsgHaveNone, MsgSuggest, MsgRejectRequest, MsgAllowedFast:
+			if err := tc.handleFastMessage(peer, &msg); err != nil {
+				log.Printf("❌ Bad fast extension message from peer: %v", err)
+				return
+			}
+
+		case MsgExtended:
+			if !tc.usesExtensions(peer) {
+				log.Println("🔎 Extended message from a peer that didn't advertise extensions")
+				break
+			}
+			id, payload, _ := ParseExtended(&msg)
+			if err := tc.Extensions.HandleExtended(peer, id, payload); err != nil {
+				log.Printf("❌ Bad extended message from peer: %v", err)
+				return
+			}
 
 		default:
 			log.Printf("🔎 Unknown message ID: %d", msg.ID)
 		}
+		if err != nil {
+			log.Printf("❌ Failed to send interest: %v", err)
+			return
+		}
+
+		if err := tc.fillRequests(peer); err != nil {
+			log.Printf("❌ Failed to send requests: %v", err)
+			return
+		}
 	}
 }
 
 func InterestedMessage() []byte {
-	buf := make([]byte, 5)
-
-	binary.BigEndian.PutUint32(buf[0:4], 1)
-	buf[4] = 2
-	return buf
+	return NewMessage(MsgInterested, nil).Serialize()
 }
 
 type Message struct {
@@ -130,9 +225,9 @@ type Message struct {
 	Payload []byte
 }
 
-func ReadMessage(conn net.Conn) (Message, error) {
+func ReadMessage(r io.Reader) (Message, error) {
 	var lengthBuf [4]byte
-	_, err := io.ReadFull(conn, lengthBuf[:])
+	_, err := io.ReadFull(r, lengthBuf[:])
 
 	if err != nil {
 		return Message{}, err
@@ -143,12 +238,15 @@ func ReadMessage(conn net.Conn) (Message, error) {
 		// keep-alive
 		return Message{Length: 0}, nil
 	}
+	if length > MaxMessageLength {
+		return Message{}, fmt.Errorf("message length %d exceeds limit of %d", length, MaxMessageLength)
+	}
 	msg := Message{
 		Length: int(length),
 	}
 
 	payload := make([]byte, length)
-	_, err = io.ReadFull(conn, payload)
+	_, err = io.ReadFull(r, payload)
 	if err != nil {
 		return Message{}, err
 	}
@@ -159,12 +257,25 @@ func ReadMessage(conn net.Conn) (Message, error) {
 
 }
 
-func ParseBitfield(payload []byte) []bool {
-	bits := []bool{}
-	for _, b := range payload {
-		for i := 7; i >= 0; i-- {
-			bits = append(bits, (b>>i)&1 == 1)
+// ParseBitfield unpacks a BITFIELD payload into one bool per piece. The
+// payload has to be exactly ceil(numPieces/8) bytes and the spare bits at
+// the end must be clear, anything else means the peer is broken.
+func ParseBitfield(payload []byte, numPieces int) ([]bool, error) {
+	if len(payload) != (numPieces+7)/8 {
+		return nil, fmt.Errorf("bitfield is %d bytes, expected %d for %d pieces", len(payload), (numPieces+7)/8, numPieces)
+	}
+	bits := make([]bool, 0, numPieces)
+	for i, b := range payload {
+		for j := 7; j >= 0; j-- {
+			set := (b>>j)&1 == 1
+			if len(bits) == numPieces {
+				if set {
+					return nil, fmt.Errorf("bitfield has spare bits set in byte %d", i)
+				}
+				continue
+			}
+			bits = append(bits, set)
 		}
 	}
-	return bits
+	return bits, nil
 }
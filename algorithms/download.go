@@ -0,0 +1,289 @@
+package algorithms
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"log"
+	"time"
+)
+
+const (
+	BlockSize            = 16 * 1024
+	DefaultPipelineDepth = 5
+)
+
+// pieceProgress is a piece being assembled, blocks can come from any peer
+// and in any order.
+type pieceProgress struct {
+	index      int
+	data       []byte
+	requesters [][]*Peer // peers with an outstanding request for the block
+	received   []bool
+	remaining  int
+}
+
+func (tc *TorrentClient) newPieceProgress(index int) *pieceProgress {
+	size := tc.PieceSize(index)
+	numBlocks := int((size + BlockSize - 1) / BlockSize)
+	return &pieceProgress{
+		index:      index,
+		data:       make([]byte, size),
+		requesters: make([][]*Peer, numBlocks),
+		received:   make([]bool, numBlocks),
+		remaining:  numBlocks,
+	}
+}
+
+func (pp *pieceProgress) blockRequest(block int) BlockRequest {
+	begin := block * BlockSize
+	length := BlockSize
+	if begin+length > len(pp.data) {
+		length = len(pp.data) - begin
+	}
+	return BlockRequest{Index: pp.index, Begin: begin, Length: length}
+}
+
+// removeRequester drops the peer from the block's requesters.
+func (pp *pieceProgress) removeRequester(block int, peer *Peer) {
+	list := pp.requesters[block]
+	for i, p := range list {
+		if p == peer {
+			pp.requesters[block] = append(list[:i], list[i+1:]...)
+			return
+		}
+	}
+}
+
+// freeBlock is the first block nobody was asked for, -1 when there is none.
+func (pp *pieceProgress) freeBlock() int {
+	for b := range pp.requesters {
+		if len(pp.requesters[b]) == 0 && !pp.received[b] {
+			return b
+		}
+	}
+	return -1
+}
+
+func (tc *TorrentClient) pipelineDepth() int {
+	if tc.PipelineDepth > 0 {
+		return tc.PipelineDepth
+	}
+	return DefaultPipelineDepth
+}
+
+// fillRequests keeps up to PipelineDepth requests in flight on the peer, or
+// fewer when the peer advertised a smaller request queue.
+func (tc *TorrentClient) fillRequests(peer *Peer) error {
+	for {
+		peer.mu.Lock()
+		inFlight := len(peer.requests)
+		choking := peer.PeerChoking
+		interested := peer.AmInterested
+		depth := tc.pipelineDepth()
+		if peer.maxRequests > 0 && peer.maxRequests < depth {
+			depth = peer.maxRequests
+		}
+		// a peer choking us still serves its allowed fast pieces
+		var allowed map[int]bool
+		if choking && len(peer.allowedFast) > 0 {
+			allowed = make(map[int]bool, len(peer.allowedFast))
+			for index := range peer.allowedFast {
+				allowed[index] = true
+			}
+		}
+		peer.mu.Unlock()
+		if (choking && allowed == nil) || !interested || inFlight >= depth {
+			return nil
+		}
+
+		req, ok := tc.nextRequest(peer, allowed)
+		if !ok {
+			return nil
+		}
+		if err := peer.Send(FormatRequest(req)); err != nil {
+			tc.releaseRequests(peer, []BlockRequest{req})
+			return err
+		}
+	}
+}
+
+// nextRequest reserves the next block to ask the peer for, the piece comes
+// from the picker and is started if nothing was requested from it yet.
+// With allowed set only those pieces are considered.
+func (tc *TorrentClient) nextRequest(peer *Peer, allowed map[int]bool) (BlockRequest, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.inProgress == nil {
+		tc.inProgress = make(map[int]*pieceProgress)
+	}
+	index, ok := tc.pickPiece(peer, allowed)
+	if !ok {
+		// nothing left to hand out, in endgame the peer duplicates a block someone else has
+		tc.updateEndgame()
+		if tc.endgame && allowed == nil {
+			return tc.endgameRequest(peer)
+		}
+		return BlockRequest{}, false
+	}
+
+	pp, started := tc.inProgress[index]
+	if !started {
+		pp = tc.newPieceProgress(index)
+		tc.inProgress[index] = pp
+		tc.Downloading[index] = true
+		tc.Pieces[index].State = Requested
+	}
+	req := tc.reserveBlock(peer, pp, pp.freeBlock())
+	tc.updateEndgame()
+	return req, true
+}
+
+// reserveBlock must be called with tc.mu held
+func (tc *TorrentClient) reserveBlock(peer *Peer, pp *pieceProgress, block int) BlockRequest {
+	req := pp.blockRequest(block)
+	pp.requesters[block] = append(pp.requesters[block], peer)
+	peer.mu.Lock()
+	if peer.requests == nil {
+		peer.requests = make(map[BlockRequest]time.Time)
+	}
+	peer.requests[req] = time.Now()
+	peer.mu.Unlock()
+	return req
+}
+
+// releaseRequests gives the blocks back so another peer can ask for them.
+func (tc *TorrentClient) releaseRequests(peer *Peer, reqs []BlockRequest) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	peer.mu.Lock()
+	for _, req := range reqs {
+		delete(peer.requests, req)
+	}
+	peer.mu.Unlock()
+
+	for _, req := range reqs {
+		pp, ok := tc.inProgress[req.Index]
+		if !ok {
+			continue
+		}
+		pp.removeRequester(req.Begin/BlockSize, peer)
+	}
+	tc.updateEndgame()
+}
+
+// dropPeerRequests forgets everything outstanding on the peer, used when it
+// disconnects.
+func (tc *TorrentClient) dropPeerRequests(peer *Peer) {
+	peer.mu.Lock()
+	reqs := make([]BlockRequest, 0, len(peer.requests))
+	for req := range peer.requests {
+		reqs = append(reqs, req)
+	}
+	peer.mu.Unlock()
+	tc.releaseRequests(peer, reqs)
+}
+
+// handleChoke forgets the outstanding requests, the peer discards its
+// queue. A fast peer answers every request it won't serve with a
+// REJECT_REQUEST instead, so its requests stay until that or the PIECE
+// arrives.
+func (tc *TorrentClient) handleChoke(peer *Peer) {
+	fast := peer.SupportsFast()
+	peer.mu.Lock()
+	peer.PeerChoking = true
+	var drop []BlockRequest
+	if !fast {
+		drop = make([]BlockRequest, 0, len(peer.requests))
+		for req := range peer.requests {
+			drop = append(drop, req)
+		}
+	}
+	peer.mu.Unlock()
+	tc.releaseRequests(peer, drop)
+}
+
+// handleBlock stores a PIECE message and verifies the piece once its last
+// block is in.
+func (tc *TorrentClient) handleBlock(peer *Peer, msg *Message) error {
+	index, begin, block, err := ParsePiece(msg)
+	if err != nil {
+		return err
+	}
+	req := BlockRequest{Index: index, Begin: begin, Length: len(block)}
+
+	peer.mu.Lock()
+	delete(peer.requests, req)
+	peer.mu.Unlock()
+
+	tc.mu.Lock()
+	pp, ok := tc.inProgress[index]
+	if !ok || begin%BlockSize != 0 || begin/BlockSize >= len(pp.received) || pp.blockRequest(begin/BlockSize) != req {
+		// late block for a piece we already finished, or one we never asked for
+		tc.mu.Unlock()
+		return nil
+	}
+	b := begin / BlockSize
+	if pp.received[b] {
+		tc.mu.Unlock()
+		return nil
+	}
+	copy(pp.data[begin:], block)
+	pp.received[b] = true
+	pp.removeRequester(b, peer)
+	// whoever else was asked for this block in endgame gets a CANCEL
+	duplicates := pp.requesters[b]
+	pp.requesters[b] = nil
+	pp.remaining--
+
+	complete := pp.remaining == 0
+	if complete {
+		delete(tc.inProgress, index)
+		tc.Pieces[index].State = Downloaded
+	}
+	tc.mu.Unlock()
+
+	tc.cancelDuplicates(req, duplicates)
+	tc.RecordDownload(peer, len(block))
+	if complete {
+		tc.verifyPiece(index, pp.data)
+	}
+	return nil
+}
+
+// verifyPiece checks the assembled piece against its hash, a bad piece goes
+// back to NotRequested and is downloaded again.
+func (tc *TorrentClient) verifyPiece(index int, data []byte) {
+	hash := sha1.Sum(data)
+	if !bytes.Equal(hash[:], tc.Pieces[index].Hash) {
+		log.Printf("❌ Piece %d failed hash check", index)
+		tc.resetPiece(index)
+		return
+	}
+
+	if tc.Storage != nil {
+		if _, err := tc.Storage.WriteAt(index, data, 0); err != nil {
+			// without the data on disk the piece is as good as lost
+			log.Printf("❌ Failed to write piece %d: %v", index, err)
+			tc.resetPiece(index)
+			return
+		}
+	}
+	if tc.OnPieceVerified != nil {
+		tc.OnPieceVerified(index, data)
+	}
+	if tc.MarkPieceVerified(index) {
+		log.Printf("✅ Piece %d verified", index)
+		tc.broadcastHave(index)
+	}
+}
+
+// resetPiece puts a piece back so it gets downloaded again.
+func (tc *TorrentClient) resetPiece(index int) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.Pieces[index].State = NotRequested
+	delete(tc.Downloading, index)
+	tc.updateEndgame()
+}
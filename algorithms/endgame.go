@@ -0,0 +1,122 @@
+package algorithms
+
+import "log"
+
+// InEndgame reports whether every remaining block has been requested and
+// the client is duplicating requests across peers.
+func (tc *TorrentClient) InEndgame() bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.endgame
+}
+
+// endgameCondition must be called with tc.mu held. Endgame starts once no
+// piece we need is left unstarted and every block of the started ones has
+// been asked for.
+func (tc *TorrentClient) endgameCondition() bool {
+	if len(tc.inProgress) == 0 {
+		return false
+	}
+	for i, piece := range tc.Pieces {
+		if piece.State == NotRequested && !tc.OwnBitfield[i] && tc.wanted(i) {
+			return false
+		}
+	}
+	for _, pp := range tc.inProgress {
+		if pp.freeBlock() >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// updateEndgame must be called with tc.mu held
+func (tc *TorrentClient) updateEndgame() {
+	active := tc.endgameCondition()
+	if active == tc.endgame {
+		return
+	}
+	tc.endgame = active
+	if active {
+		tc.Strategy = "endgame"
+		log.Println("🏁 Entering endgame mode")
+	} else {
+		log.Println("🏁 Leaving endgame mode")
+	}
+	if tc.OnEndgameChange != nil {
+		tc.queueEndgameChange(active)
+	}
+}
+
+// queueEndgameChange must be called with tc.mu held. The hook runs outside
+// the lock since it may well look at the client.
+func (tc *TorrentClient) queueEndgameChange(active bool) {
+	tc.endgameEvents = append(tc.endgameEvents, active)
+	if !tc.endgameNotifying {
+		tc.endgameNotifying = true
+		go tc.notifyEndgame()
+	}
+}
+
+// notifyEndgame hands the queued endgame changes to OnEndgameChange one by
+// one, so an exit is never seen before the entry it follows.
+func (tc *TorrentClient) notifyEndgame() {
+	for {
+		tc.mu.Lock()
+		if len(tc.endgameEvents) == 0 {
+			tc.endgameNotifying = false
+			tc.mu.Unlock()
+			return
+		}
+		active := tc.endgameEvents[0]
+		tc.endgameEvents = tc.endgameEvents[1:]
+		tc.mu.Unlock()
+		tc.OnEndgameChange(active)
+	}
+}
+
+// endgameRequest must be called with tc.mu held. It picks the outstanding
+// block with the fewest requesters that this peer has and was not asked
+// for yet.
+func (tc *TorrentClient) endgameRequest(peer *Peer) (BlockRequest, bool) {
+	var best *pieceProgress
+	bestBlock := -1
+	for index, pp := range tc.inProgress {
+		if !peer.HasPiece(index) {
+			continue
+		}
+		for b, requesters := range pp.requesters {
+			if pp.received[b] || containsPeer(requesters, peer) {
+				continue
+			}
+			if best == nil || len(requesters) < len(best.requesters[bestBlock]) {
+				best, bestBlock = pp, b
+			}
+		}
+	}
+	if best == nil {
+		return BlockRequest{}, false
+	}
+	return tc.reserveBlock(peer, best, bestBlock), true
+}
+
+// cancelDuplicates tells the other peers we no longer need the block.
+func (tc *TorrentClient) cancelDuplicates(req BlockRequest, peers []*Peer) {
+	for _, p := range peers {
+		p.mu.Lock()
+		delete(p.requests, req)
+		p.mu.Unlock()
+		if err := p.Send(FormatCancel(req)); err != nil {
+			log.Printf("❌ Failed to send CANCEL to %s: %v", p.IP, err)
+		}
+	}
+}
+
+func containsPeer(peers []*Peer, peer *Peer) bool {
+	for _, p := range peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package algorithms
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakePeer is a connected peer whose side of the wire is read by the test.
+type fakePeer struct {
+	peer *Peer
+	msgs chan Message
+}
+
+func newFakePeer(t *testing.T, tc *TorrentClient, ip net.IP) *fakePeer {
+	t.Helper()
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+
+	f := &fakePeer{
+		peer: &Peer{IP: ip, Conn: local, HandshakeDone: true, AmInterested: true},
+		msgs: make(chan Message, 64),
+	}
+	bits := make([]bool, tc.TotalPieces)
+	for i := range bits {
+		bits[i] = true
+	}
+	tc.SetPeerBitfield(f.peer, bits)
+
+	go func() {
+		for {
+			msg, err := ReadMessage(remote)
+			if err != nil {
+				return
+			}
+			f.msgs <- msg
+		}
+	}()
+	return f
+}
+
+// next waits for the next message of the given type, others are skipped.
+func (f *fakePeer) next(t *testing.T, id byte) BlockRequest {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-f.msgs:
+			if msg.ID != id {
+				continue
+			}
+			req, err := parseBlockRequest(id, &msg)
+			if err != nil {
+				t.Fatalf("bad message %d: %v", id, err)
+			}
+			return req
+		case <-timeout:
+			t.Fatalf("no message %d from the client", id)
+		}
+	}
+}
+
+func TestEndgameDoesNotStallOnSlowPeer(t *testing.T) {
+	const pieceLength = 2 * BlockSize
+	data := make([]byte, 2*pieceLength)
+	rand.Read(data)
+	var hashes [][20]byte
+	for i := 0; i < len(data); i += pieceLength {
+		hashes = append(hashes, sha1.Sum(data[i:i+pieceLength]))
+	}
+
+	events := make(chan bool, 16)
+	tc := &TorrentClient{
+		PieceLength:     pieceLength,
+		TotalLength:     int64(len(data)),
+		PipelineDepth:   2,
+		OnEndgameChange: func(active bool) { events <- active },
+	}
+	tc.InitPieces(hashes)
+
+	slow := newFakePeer(t, tc, net.IPv4(10, 0, 0, 1))
+	fast := newFakePeer(t, tc, net.IPv4(10, 0, 0, 2))
+
+	// the slow peer takes one piece and never answers
+	if err := tc.fillRequests(slow.peer); err != nil {
+		t.Fatalf("fillRequests(slow): %v", err)
+	}
+	slowRequests := map[BlockRequest]bool{
+		slow.next(t, MsgRequest): true,
+		slow.next(t, MsgRequest): true,
+	}
+
+	if err := tc.fillRequests(fast.peer); err != nil {
+		t.Fatalf("fillRequests(fast): %v", err)
+	}
+	for done := false; !done; {
+		req := fast.next(t, MsgRequest)
+		block := data[req.Index*pieceLength+req.Begin:][:req.Length]
+		if err := tc.handleBlock(fast.peer, FormatPiece(req.Index, req.Begin, block)); err != nil {
+			t.Fatalf("handleBlock: %v", err)
+		}
+		select {
+		case <-tc.Done():
+			done = true
+		default:
+			if err := tc.fillRequests(fast.peer); err != nil {
+				t.Fatalf("fillRequests(fast): %v", err)
+			}
+		}
+	}
+
+	// the duplicates the fast peer answered are cancelled on the slow one
+	for len(slowRequests) > 0 {
+		cancel := slow.next(t, MsgCancel)
+		if !slowRequests[cancel] {
+			t.Fatalf("unexpected CANCEL %+v", cancel)
+		}
+		delete(slowRequests, cancel)
+	}
+
+	select {
+	case active := <-events:
+		if !active {
+			t.Fatal("first endgame change was an exit")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("endgame was never entered")
+	}
+}
+
+func TestEndgameChangesArriveInOrder(t *testing.T) {
+	events := make(chan bool, 100)
+	tc := &TorrentClient{OnEndgameChange: func(active bool) { events <- active }}
+
+	// flip endgame back and forth faster than the hook can keep up
+	tc.mu.Lock()
+	for i := 0; i < 50; i++ {
+		tc.queueEndgameChange(i%2 == 0)
+	}
+	tc.mu.Unlock()
+
+	for i := 0; i < 50; i++ {
+		select {
+		case active := <-events:
+			if want := i%2 == 0; active != want {
+				t.Fatalf("event %d = %v, want %v", i, active, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d events delivered", i)
+		}
+	}
+}
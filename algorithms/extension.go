@@ -0,0 +1,52 @@
+package algorithms
+
+// the extension protocol (BEP 10) is advertised with bit 0x10 of reserved byte 5
+const (
+	extensionReservedByte = 5
+	extensionReservedBit  = 0x10
+)
+
+// ExtensionHandler speaks the extension protocol on the torrent's behalf, it
+// only hears from peers that set the extension bit in their handshake.
+type ExtensionHandler interface {
+	// PeerConnected is called when the peer's message loop starts, it is
+	// where the extended handshake goes out
+	PeerConnected(peer *Peer) error
+	// HandleExtended gets every message with id 20, an error drops the peer
+	HandleExtended(peer *Peer, id byte, payload []byte) error
+	PeerDisconnected(peer *Peer)
+}
+
+// SupportsExtensions reports whether the handshake advertised BEP 10.
+func (h *Handshake) SupportsExtensions() bool {
+	return h.Reserved[extensionReservedByte]&extensionReservedBit != 0
+}
+
+// SupportsExtensions reports whether the peer's handshake advertised BEP 10.
+func (p *Peer) SupportsExtensions() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Reserved[extensionReservedByte]&extensionReservedBit != 0
+}
+
+// SendExtended sends an extension message with the id the peer assigned.
+func (p *Peer) SendExtended(id byte, payload []byte) error {
+	return p.Send(FormatExtended(id, payload))
+}
+
+// reserved is what we put in our handshake, the fast extension is always
+// on and the extension bit is only set when something is there to handle
+// extension messages.
+func (tc *TorrentClient) reserved() [8]byte {
+	var reserved [8]byte
+	reserved[fastReservedByte] |= fastReservedBit
+	if tc.Extensions != nil {
+		reserved[extensionReservedByte] |= extensionReservedBit
+	}
+	return reserved
+}
+
+// usesExtensions is true when both sides advertised the extension protocol.
+func (tc *TorrentClient) usesExtensions(peer *Peer) bool {
+	return tc.Extensions != nil && peer.SupportsExtensions()
+}
@@ -0,0 +1,262 @@
+package algorithms
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+)
+
+// the fast extension (BEP 6) is advertised with bit 0x04 of reserved byte 7
+const (
+	fastReservedByte = 7
+	fastReservedBit  = 0x04
+)
+
+// AllowedFastCount is how many pieces a peer we choke may still request,
+// k in BEP 6.
+const AllowedFastCount = 10
+
+// maxFastHints caps the SUGGEST_PIECE and ALLOWED_FAST entries we remember
+// per peer, a peer can't make us keep a list of every piece.
+const maxFastHints = 32
+
+// SupportsFast reports whether the handshake advertised the fast extension.
+func (h *Handshake) SupportsFast() bool {
+	return h.Reserved[fastReservedByte]&fastReservedBit != 0
+}
+
+// SupportsFast reports whether the peer's handshake advertised the fast
+// extension, we always do so it is in use whenever this is true.
+func (p *Peer) SupportsFast() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Reserved[fastReservedByte]&fastReservedBit != 0
+}
+
+// AllowedFastSet is the canonical allowed fast set from BEP 6: the pieces
+// follow from the peer's /24 and the info hash, so both sides can work them
+// out. It is only defined for IPv4 peers.
+func AllowedFastSet(ip net.IP, infoHash [20]byte, numPieces, k int) []int {
+	ip4 := ip.To4()
+	if ip4 == nil || numPieces <= 0 {
+		return nil
+	}
+	k = min(k, numPieces)
+
+	x := make([]byte, 0, 24)
+	x = append(x, ip4[0], ip4[1], ip4[2], 0)
+	x = append(x, infoHash[:]...)
+
+	set := make([]int, 0, k)
+	seen := make(map[int]bool, k)
+	for len(set) < k {
+		sum := sha1.Sum(x)
+		x = sum[:]
+		for i := 0; i < 5 && len(set) < k; i++ {
+			index := int(binary.BigEndian.Uint32(x[i*4:]) % uint32(numPieces))
+			if !seen[index] {
+				seen[index] = true
+				set = append(set, index)
+			}
+		}
+	}
+	return set
+}
+
+// sendAllowedFast tells a fast peer which of our pieces it may request
+// while we choke it, set members we don't have yet are offered by
+// offerAllowedFast once they are verified.
+func (tc *TorrentClient) sendAllowedFast(peer *Peer) error {
+	if !peer.SupportsFast() {
+		return nil
+	}
+	tc.mu.Lock()
+	set := AllowedFastSet(peer.IP, tc.InfoHash, tc.TotalPieces, AllowedFastCount)
+	var have []int
+	for _, index := range set {
+		if tc.OwnBitfield[index] {
+			have = append(have, index)
+		}
+	}
+	tc.mu.Unlock()
+
+	for _, index := range markAllowedFast(peer, have) {
+		if err := peer.Send(FormatAllowedFast(index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offerAllowedFast sends ALLOWED_FAST for a piece we just verified if it is
+// in the peer's allowed fast set.
+func (tc *TorrentClient) offerAllowedFast(peer *Peer, index int) error {
+	if !peer.SupportsFast() {
+		return nil
+	}
+	tc.mu.Lock()
+	set := AllowedFastSet(peer.IP, tc.InfoHash, tc.TotalPieces, AllowedFastCount)
+	tc.mu.Unlock()
+
+	for _, member := range set {
+		if member != index {
+			continue
+		}
+		if len(markAllowedFast(peer, []int{index})) == 0 {
+			return nil
+		}
+		return peer.Send(FormatAllowedFast(index))
+	}
+	return nil
+}
+
+// markAllowedFast records the pieces as allowed fast for the peer and
+// returns the ones that weren't already, only those still need sending.
+func markAllowedFast(peer *Peer, indices []int) []int {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.allowedFastSent == nil {
+		peer.allowedFastSent = make(map[int]bool, len(indices))
+	}
+	var added []int
+	for _, index := range indices {
+		if !peer.allowedFastSent[index] {
+			peer.allowedFastSent[index] = true
+			added = append(added, index)
+		}
+	}
+	return added
+}
+
+// handleHaveAll and handleHaveNone stand in for a BITFIELD, before the
+// metadata is known HAVE_ALL is kept until InitPieces.
+func (tc *TorrentClient) handleHaveAll(peer *Peer) error {
+	tc.mu.Lock()
+	if !tc.metadata {
+		peer.mu.Lock()
+		peer.pendingHaveAll = true
+		peer.pendingBitfield = nil
+		peer.pendingHaves = nil
+		peer.mu.Unlock()
+		tc.mu.Unlock()
+		return nil
+	}
+	bits := make([]bool, tc.TotalPieces)
+	tc.mu.Unlock()
+
+	for i := range bits {
+		bits[i] = true
+	}
+	tc.SetPeerBitfield(peer, bits)
+	return tc.updateInterest(peer)
+}
+
+func (tc *TorrentClient) handleHaveNone(peer *Peer) error {
+	tc.mu.Lock()
+	if !tc.metadata {
+		peer.mu.Lock()
+		peer.pendingHaveAll = false
+		peer.pendingBitfield = nil
+		peer.pendingHaves = nil
+		peer.mu.Unlock()
+		tc.mu.Unlock()
+		return nil
+	}
+	bits := make([]bool, tc.TotalPieces)
+	tc.mu.Unlock()
+
+	tc.SetPeerBitfield(peer, bits)
+	return tc.updateInterest(peer)
+}
+
+// handleSuggest remembers a piece the peer would like us to download, the
+// newest suggestions are kept.
+func (tc *TorrentClient) handleSuggest(peer *Peer, index int) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	for _, s := range peer.suggested {
+		if s == index {
+			return
+		}
+	}
+	if len(peer.suggested) == maxFastHints {
+		peer.suggested = peer.suggested[1:]
+	}
+	peer.suggested = append(peer.suggested, index)
+}
+
+// handleAllowedFast records a piece we may request while the peer chokes us.
+func (tc *TorrentClient) handleAllowedFast(peer *Peer, index int) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.allowedFast == nil {
+		peer.allowedFast = make(map[int]bool)
+	}
+	if len(peer.allowedFast) < maxFastHints {
+		peer.allowedFast[index] = true
+	}
+}
+
+// handleReject gives a rejected block back so it can be asked for
+// elsewhere. The piece is no longer allowed fast from this peer either,
+// otherwise the next fillRequests would ask it again.
+func (tc *TorrentClient) handleReject(peer *Peer, msg *Message) error {
+	req, err := ParseRejectRequest(msg)
+	if err != nil {
+		return err
+	}
+	peer.mu.Lock()
+	_, requested := peer.requests[req]
+	delete(peer.allowedFast, req.Index)
+	peer.mu.Unlock()
+	if !requested {
+		// a reject for a request we already gave up on, e.g. after a CANCEL
+		return nil
+	}
+	tc.releaseRequests(peer, []BlockRequest{req})
+	return nil
+}
+
+// rejectRequest tells a fast peer we won't serve the request, without the
+// fast extension it is just dropped.
+func (tc *TorrentClient) rejectRequest(peer *Peer, req BlockRequest) {
+	if !peer.SupportsFast() {
+		return
+	}
+	if err := peer.Send(FormatRejectRequest(req)); err != nil {
+		log.Printf("❌ Failed to reject request from %s: %v", peer.IP, err)
+	}
+}
+
+// requireFast drops a peer that sends fast extension messages without
+// having advertised it.
+func requireFast(peer *Peer, id byte) error {
+	if !peer.SupportsFast() {
+		return fmt.Errorf("message id %d without the fast extension", id)
+	}
+	return nil
+}
+
+// handleFastMessage is PeerLoop's entry point for the BEP 6 messages.
+func (tc *TorrentClient) handleFastMessage(peer *Peer, msg *Message) error {
+	if err := requireFast(peer, msg.ID); err != nil {
+		return err
+	}
+	switch msg.ID {
+	case MsgHaveAll:
+		return tc.handleHaveAll(peer)
+	case MsgHaveNone:
+		return tc.handleHaveNone(peer)
+	case MsgSuggest:
+		index, _ := ParseSuggest(msg)
+		tc.handleSuggest(peer, index)
+	case MsgRejectRequest:
+		return tc.handleReject(peer, msg)
+	case MsgAllowedFast:
+		index, _ := ParseAllowedFast(msg)
+		tc.handleAllowedFast(peer, index)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package algorithms
+
+import (
+	"fmt"
+	"io"
+)
+
+const protocolString = "BitTorrent protocol"
+
+// Handshake is the 68 byte message that opens every peer connection.
+type Handshake struct {
+	Reserved [8]byte
+	InfoHash [20]byte
+	PeerID   [20]byte
+}
+
+func (h *Handshake) Serialize() []byte {
+	buf := make([]byte, 68)
+	buf[0] = byte(len(protocolString))
+	copy(buf[1:], protocolString)
+	copy(buf[20:28], h.Reserved[:])
+	copy(buf[28:48], h.InfoHash[:])
+	copy(buf[48:68], h.PeerID[:])
+	return buf
+}
+
+func WriteHandshake(w io.Writer, h *Handshake) error {
+	_, err := w.Write(h.Serialize())
+	return err
+}
+
+func ReadHandshake(r io.Reader) (*Handshake, error) {
+	buf := make([]byte, 68)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if int(buf[0]) != len(protocolString) || string(buf[1:20]) != protocolString {
+		return nil, fmt.Errorf("unknown protocol %q", buf[1:min(1+int(buf[0]), 68)])
+	}
+
+	h := &Handshake{}
+	copy(h.Reserved[:], buf[20:28])
+	copy(h.InfoHash[:], buf[28:48])
+	copy(h.PeerID[:], buf[48:68])
+	return h, nil
+}
+
+func peerIDBytes(peerID string) [20]byte {
+	var id [20]byte
+	copy(id[:], peerID)
+	return id
+}
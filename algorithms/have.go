@@ -0,0 +1,147 @@
+package algorithms
+
+import "log"
+
+// sendBitfield is the first message after the handshake, a peer with
+// nothing to offer may skip it so we do too. A fast peer gets HAVE_ALL or
+// HAVE_NONE when they say the same in fewer bytes, and it must get one of
+// the three. The snapshot, the write and HandshakeDone share writeMu, so a
+// piece verified meanwhile is either in the bitfield or announced after it.
+func (tc *TorrentClient) sendBitfield(peer *Peer) error {
+	peer.writeMu.Lock()
+	defer peer.writeMu.Unlock()
+
+	tc.mu.Lock()
+	bits := make([]bool, len(tc.OwnBitfield))
+	copy(bits, tc.OwnBitfield)
+	tc.mu.Unlock()
+
+	var msg *Message
+	count := countSet(bits)
+	switch {
+	case peer.SupportsFast() && count == 0:
+		msg = NewMessage(MsgHaveNone, nil)
+	case peer.SupportsFast() && count == len(bits):
+		msg = NewMessage(MsgHaveAll, nil)
+	case count > 0:
+		msg = FormatBitfield(bits)
+	}
+	if msg != nil {
+		if err := peer.write(msg); err != nil {
+			return err
+		}
+	}
+	peer.mu.Lock()
+	peer.HandshakeDone = true
+	peer.mu.Unlock()
+	return nil
+}
+
+// broadcastHave tells every connected peer about a newly verified piece,
+// offers it as allowed fast where it belongs to the peer's set and drops our
+// interest in peers that have nothing else for us.
+func (tc *TorrentClient) broadcastHave(index int) {
+	msg := FormatHave(index)
+	for _, peer := range tc.PeerList() {
+		sent, err := peer.sendConnected(msg)
+		if err != nil {
+			log.Printf("❌ Failed to send HAVE to %s: %v", peer.IP, err)
+			continue
+		}
+		if !sent {
+			// not past sendBitfield yet, the bitfield will include the piece
+			continue
+		}
+		if err := tc.offerAllowedFast(peer, index); err != nil {
+			log.Printf("❌ Failed to send ALLOWED_FAST to %s: %v", peer.IP, err)
+			continue
+		}
+		if err := tc.updateInterest(peer); err != nil {
+			log.Printf("❌ Failed to update interest in %s: %v", peer.IP, err)
+		}
+	}
+}
+
+// updateInterest sends INTERESTED when the peer has a piece we lack and
+// NOT_INTERESTED once it doesn't, nothing is sent if the state is unchanged.
+// The decision and the write share writeMu, otherwise PeerLoop and
+// broadcastHave could put the two messages on the wire in the wrong order.
+func (tc *TorrentClient) updateInterest(peer *Peer) error {
+	peer.writeMu.Lock()
+	defer peer.writeMu.Unlock()
+
+	interested := tc.wantsFrom(peer)
+	peer.mu.Lock()
+	changed := peer.AmInterested != interested
+	peer.AmInterested = interested
+	peer.mu.Unlock()
+	if !changed {
+		return nil
+	}
+
+	if interested {
+		log.Println("📨 Sent INTERESTED to peer")
+		return peer.write(NewMessage(MsgInterested, nil))
+	}
+	log.Println("📨 Sent NOT_INTERESTED to peer")
+	return peer.write(NewMessage(MsgNotInterested, nil))
+}
+
+func (tc *TorrentClient) wantsFrom(peer *Peer) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	for i, have := range tc.OwnBitfield {
+		if !have && tc.wanted(i) && peer.HasPiece(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// Connected reports whether the peer has a live connection and got our
+// bitfield.
+func (p *Peer) Connected() bool {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.connected()
+}
+
+// sendConnected writes the message only if the peer is Connected, checked
+// under the same writeMu so it can't go out before the bitfield. It reports
+// whether the message was written.
+func (p *Peer) sendConnected(msg *Message) (bool, error) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if !p.connected() {
+		return false, nil
+	}
+	return true, p.write(msg)
+}
+
+// connected must be called with p.writeMu held
+func (p *Peer) connected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Conn != nil && p.HandshakeDone
+}
+
+// PeerIsSeed reports whether the peer has every piece, false while the
+// metadata is unknown.
+func (tc *TorrentClient) PeerIsSeed(peer *Peer) bool {
+	tc.mu.Lock()
+	total := tc.TotalPieces
+	tc.mu.Unlock()
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	return total > 0 && countSet(peer.Bitfield) == total
+}
+
+func countSet(bits []bool) int {
+	n := 0
+	for _, b := range bits {
+		if b {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,242 @@
+package algorithms
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// peer wire message ids
+const (
+	MsgChoke         byte = 0
+	MsgUnchoke       byte = 1
+	MsgInterested    byte = 2
+	MsgNotInterested byte = 3
+	MsgHave          byte = 4
+	MsgBitfield      byte = 5
+	MsgRequest       byte = 6
+	MsgPiece         byte = 7
+	MsgCancel        byte = 8
+	MsgPort          byte = 9
+	// fast extension (BEP 6)
+	MsgSuggest       byte = 0x0D
+	MsgHaveAll       byte = 0x0E
+	MsgHaveNone      byte = 0x0F
+	MsgRejectRequest byte = 0x10
+	MsgAllowedFast   byte = 0x11
+	MsgExtended      byte = 20
+)
+
+// MaxMessageLength caps the length prefix we accept, a peer claiming more is
+// either broken or trying to make us allocate memory.
+const MaxMessageLength = 1 << 20
+
+// NewMessage builds a message with the length prefix filled in.
+func NewMessage(id byte, payload []byte) *Message {
+	return &Message{Length: 1 + len(payload), ID: id, Payload: payload}
+}
+
+func (m *Message) IsKeepAlive() bool {
+	return m.Length == 0
+}
+
+// Serialize gives the wire form, <length prefix><id><payload>.
+func (m *Message) Serialize() []byte {
+	if m.IsKeepAlive() {
+		return make([]byte, 4)
+	}
+	buf := make([]byte, 5+len(m.Payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(1+len(m.Payload)))
+	buf[4] = m.ID
+	copy(buf[5:], m.Payload)
+	return buf
+}
+
+func WriteMessage(w io.Writer, m *Message) error {
+	_, err := w.Write(m.Serialize())
+	return err
+}
+
+func (m *Message) expect(id byte, payloadLen int) error {
+	if m.ID != id {
+		return fmt.Errorf("expected message id %d, got %d", id, m.ID)
+	}
+	if payloadLen >= 0 && len(m.Payload) != payloadLen {
+		return fmt.Errorf("message id %d: payload length %d, expected %d", id, len(m.Payload), payloadLen)
+	}
+	return nil
+}
+
+// formatIndex is the payload of HAVE, SUGGEST_PIECE and ALLOWED_FAST, a
+// single piece index.
+func formatIndex(id byte, index int) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	return NewMessage(id, payload)
+}
+
+func parseIndex(id byte, m *Message) (int, error) {
+	if err := m.expect(id, 4); err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(m.Payload)), nil
+}
+
+func FormatHave(index int) *Message {
+	return formatIndex(MsgHave, index)
+}
+
+func ParseHave(m *Message) (int, error) {
+	return parseIndex(MsgHave, m)
+}
+
+func FormatSuggest(index int) *Message {
+	return formatIndex(MsgSuggest, index)
+}
+
+func ParseSuggest(m *Message) (int, error) {
+	return parseIndex(MsgSuggest, m)
+}
+
+func FormatAllowedFast(index int) *Message {
+	return formatIndex(MsgAllowedFast, index)
+}
+
+func ParseAllowedFast(m *Message) (int, error) {
+	return parseIndex(MsgAllowedFast, m)
+}
+
+func FormatBitfield(bits []bool) *Message {
+	payload := make([]byte, (len(bits)+7)/8)
+	for i, have := range bits {
+		if have {
+			payload[i/8] |= 1 << (7 - uint(i%8))
+		}
+	}
+	return NewMessage(MsgBitfield, payload)
+}
+
+// BlockRequest is the payload of REQUEST, CANCEL and REJECT_REQUEST.
+type BlockRequest struct {
+	Index  int
+	Begin  int
+	Length int
+}
+
+func formatBlockRequest(id byte, req BlockRequest) *Message {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(req.Index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(req.Begin))
+	binary.BigEndian.PutUint32(payload[8:12], uint32(req.Length))
+	return NewMessage(id, payload)
+}
+
+func parseBlockRequest(id byte, m *Message) (BlockRequest, error) {
+	if err := m.expect(id, 12); err != nil {
+		return BlockRequest{}, err
+	}
+	return BlockRequest{
+		Index:  int(binary.BigEndian.Uint32(m.Payload[0:4])),
+		Begin:  int(binary.BigEndian.Uint32(m.Payload[4:8])),
+		Length: int(binary.BigEndian.Uint32(m.Payload[8:12])),
+	}, nil
+}
+
+func FormatRequest(req BlockRequest) *Message {
+	return formatBlockRequest(MsgRequest, req)
+}
+
+func ParseRequest(m *Message) (BlockRequest, error) {
+	return parseBlockRequest(MsgRequest, m)
+}
+
+func FormatCancel(req BlockRequest) *Message {
+	return formatBlockRequest(MsgCancel, req)
+}
+
+func ParseCancel(m *Message) (BlockRequest, error) {
+	return parseBlockRequest(MsgCancel, m)
+}
+
+func FormatRejectRequest(req BlockRequest) *Message {
+	return formatBlockRequest(MsgRejectRequest, req)
+}
+
+func ParseRejectRequest(m *Message) (BlockRequest, error) {
+	return parseBlockRequest(MsgRejectRequest, m)
+}
+
+func FormatPiece(index, begin int, block []byte) *Message {
+	payload := make([]byte, 8+len(block))
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	copy(payload[8:], block)
+	return NewMessage(MsgPiece, payload)
+}
+
+// ParsePiece returns the block without copying, it points into m.Payload.
+func ParsePiece(m *Message) (index, begin int, block []byte, err error) {
+	if err := m.expect(MsgPiece, -1); err != nil {
+		return 0, 0, nil, err
+	}
+	if len(m.Payload) < 8 {
+		return 0, 0, nil, fmt.Errorf("piece payload too short: %d bytes", len(m.Payload))
+	}
+	index = int(binary.BigEndian.Uint32(m.Payload[0:4]))
+	begin = int(binary.BigEndian.Uint32(m.Payload[4:8]))
+	return index, begin, m.Payload[8:], nil
+}
+
+func FormatPort(port uint16) *Message {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, port)
+	return NewMessage(MsgPort, payload)
+}
+
+func ParsePort(m *Message) (uint16, error) {
+	if err := m.expect(MsgPort, 2); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(m.Payload), nil
+}
+
+// FormatExtended wraps an extension protocol message, id 0 is the extended
+// handshake and the others are whatever the peer assigned in it.
+func FormatExtended(id byte, payload []byte) *Message {
+	buf := make([]byte, 1+len(payload))
+	buf[0] = id
+	copy(buf[1:], payload)
+	return NewMessage(MsgExtended, buf)
+}
+
+func ParseExtended(m *Message) (byte, []byte, error) {
+	if err := m.expect(MsgExtended, -1); err != nil {
+		return 0, nil, err
+	}
+	if len(m.Payload) < 1 {
+		return 0, nil, fmt.Errorf("extended message has no extension id")
+	}
+	return m.Payload[0], m.Payload[1:], nil
+}
+
+// ValidateMessage checks the payload length of the messages that have a
+// fixed size, so PeerLoop can drop a misbehaving peer before acting on it.
+func ValidateMessage(m *Message) error {
+	switch m.ID {
+	case MsgChoke, MsgUnchoke, MsgInterested, MsgNotInterested, MsgHaveAll, MsgHaveNone:
+		return m.expect(m.ID, 0)
+	case MsgHave, MsgSuggest, MsgAllowedFast:
+		return m.expect(m.ID, 4)
+	case MsgRequest, MsgCancel, MsgRejectRequest:
+		return m.expect(m.ID, 12)
+	case MsgPort:
+		return m.expect(m.ID, 2)
+	case MsgPiece:
+		_, _, _, err := ParsePiece(m)
+		return err
+	case MsgExtended:
+		_, _, err := ParseExtended(m)
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package algorithms
 
 import (
+	"fmt"
 	"net"
 	"sync"
 	"time"
@@ -20,5 +21,88 @@ type Peer struct {
 	SnubbedUntil    time.Time
 	mu              sync.Mutex
 	Bitfield        []bool
-	HandshakeDone   bool
+	// HandshakeDone is set once our BITFIELD (or HAVE_ALL, HAVE_NONE) went
+	// out, nothing is broadcast to the peer before that
+	HandshakeDone bool
+	ID            [20]byte
+	// Reserved holds the feature bits from the peer's handshake
+	Reserved [8]byte
+	// Inbound is set when the peer connected to us, PORT is then its
+	// ephemeral port and not one it listens on
+	Inbound bool
+	// totals for the whole session, BytesDownloaded is reset by the rate checker
+	Downloaded int64
+	Uploaded   int64
+	// Choked is our choke on the peer, PeerChoking is the peer's choke on us
+	PeerChoking bool
+	// AmInterested is our interest in the peer, Interested is the peer's in us
+	AmInterested bool
+	// blocks we asked this peer for and have not received yet
+	requests map[BlockRequest]time.Time
+	// maxRequests is the request queue size the peer advertised, 0 if it didn't
+	maxRequests int
+	// blocks the peer asked us for, served by serveUploads
+	uploadQueue []BlockRequest
+	uploadReady chan struct{}
+	// writeMu serializes writes to Conn, when held together with tc.mu or
+	// mu it is taken first
+	writeMu sync.Mutex
+	// BITFIELD, HAVE and HAVE_ALL messages received before the torrent's metadata was known
+	pendingBitfield []byte
+	pendingHaves    []int
+	pendingHaveAll  bool
+	// fast extension state, pieces the peer lets us request while it chokes
+	// us, the ones we let it request while we choke it and its suggestions
+	allowedFast     map[int]bool
+	allowedFastSent map[int]bool
+	suggested       []int
+}
+
+// Send writes a message to the peer, it is safe to call from any goroutine.
+func (p *Peer) Send(msg *Message) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.write(msg)
+}
+
+// write must be called with p.writeMu held
+func (p *Peer) write(msg *Message) error {
+	if p.Conn == nil {
+		return fmt.Errorf("peer %s is not connected", p.IP)
+	}
+	_, err := p.Conn.Write(msg.Serialize())
+	return err
+}
+
+// disconnect forgets the connection so later sends fail instead of writing to a closed socket.
+func (p *Peer) disconnect() {
+	p.writeMu.Lock()
+	p.Conn = nil
+	p.writeMu.Unlock()
+	p.mu.Lock()
+	p.HandshakeDone = false
+	p.mu.Unlock()
+}
+
+// close shuts the connection down, PeerLoop notices and cleans up.
+func (p *Peer) close() {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if p.Conn != nil {
+		p.Conn.Close()
+	}
+}
+
+// SetMaxRequests caps the requests kept in flight on the peer below the
+// client's pipeline depth, 0 removes the cap.
+func (p *Peer) SetMaxRequests(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxRequests = n
+}
+
+func (p *Peer) HasPiece(index int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return index >= 0 && index < len(p.Bitfield) && p.Bitfield[index]
 }
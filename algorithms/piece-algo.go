@@ -1,5 +1,10 @@
 package algorithms
 
+import (
+	"fmt"
+	"log"
+)
+
 // ! Rarest piece
 // we have to ensure that the rarest piece are distributed b/w the peer so that each peer have the rarest piece and each can get the good dowload speed
 // ! Random Policy
@@ -28,15 +33,25 @@ type Piece struct {
 	IsVerified bool
 }
 
-func (tc *TorrentClient) InitPieces(pieceHashes [][]byte) {
-
+// InitPieces sets up the piece table, for a torrent started from a magnet
+// link it is called once the metadata arrives and the BITFIELD and HAVE
+// messages peers sent in the meantime are applied then.
+func (tc *TorrentClient) InitPieces(pieceHashes [][20]byte) {
+	tc.mu.Lock()
 	tc.TotalPieces = len(pieceHashes)
 	tc.OwnBitfield = make([]bool, tc.TotalPieces)
 	tc.Downloading = make(map[int]bool)
 	tc.PieceHashMap = make(map[int][]byte)
 	tc.Pieces = make([]*Piece, tc.TotalPieces)
+	if len(tc.selected) != tc.TotalPieces {
+		tc.selected = nil
+	}
+	if tc.done == nil {
+		tc.done = make(chan struct{})
+	}
 
-	for i, hash := range pieceHashes {
+	for i := range pieceHashes {
+		hash := pieceHashes[i][:]
 		tc.PieceHashMap[i] = hash
 		tc.Pieces[i] = &Piece{
 			Index:  i,
@@ -45,10 +60,217 @@ func (tc *TorrentClient) InitPieces(pieceHashes [][]byte) {
 			Hash:   hash,
 		}
 	}
+	tc.metadata = true
+	if tc.selected != nil {
+		tc.checkDone()
+	}
+	peers := make([]*Peer, 0, len(tc.Peers))
+	for _, peer := range tc.Peers {
+		peers = append(peers, peer)
+	}
+	tc.mu.Unlock()
+
+	for _, peer := range peers {
+		tc.applyPendingPeerState(peer)
+	}
+}
+
+// deferPeerState holds on to a BITFIELD payload (have < 0) or a HAVE index
+// while the metadata is unknown, they can't be checked without the piece
+// count. It reports false once the metadata is there.
+func (tc *TorrentClient) deferPeerState(peer *Peer, bitfield []byte, have int) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.metadata {
+		return false
+	}
+	peer.mu.Lock()
+	if have < 0 {
+		peer.pendingBitfield = bitfield
+		peer.pendingHaves = nil
+		peer.pendingHaveAll = false
+	} else {
+		peer.pendingHaves = append(peer.pendingHaves, have)
+	}
+	peer.mu.Unlock()
+	return true
+}
+
+// applyPendingPeerState validates what the peer announced before we had the
+// metadata, a peer that turns out to have sent garbage is disconnected.
+func (tc *TorrentClient) applyPendingPeerState(peer *Peer) {
+	peer.mu.Lock()
+	bitfield, haves, haveAll := peer.pendingBitfield, peer.pendingHaves, peer.pendingHaveAll
+	peer.pendingBitfield, peer.pendingHaves, peer.pendingHaveAll = nil, nil, false
+	peer.mu.Unlock()
+	if bitfield == nil && haves == nil && !haveAll {
+		return
+	}
+
+	err := func() error {
+		if haveAll {
+			if err := tc.handleHaveAll(peer); err != nil {
+				return err
+			}
+		}
+		if bitfield != nil {
+			bits, err := ParseBitfield(bitfield, tc.TotalPieces)
+			if err != nil {
+				return err
+			}
+			tc.SetPeerBitfield(peer, bits)
+		}
+		for _, index := range haves {
+			if err := tc.PeerHasPiece(peer, index); err != nil {
+				return err
+			}
+		}
+		if err := tc.updateInterest(peer); err != nil {
+			return err
+		}
+		return tc.fillRequests(peer)
+	}()
+	if err != nil {
+		log.Printf("❌ Dropping peer %s: %v", peer.IP, err)
+		peer.close()
+	}
 }
 
-func (tc *TorrentClient) RarestPiece() {
+// PieceSize is the length of piece index, only the last piece can be shorter.
+func (tc *TorrentClient) PieceSize(index int) int64 {
+	start := int64(index) * tc.PieceLength
+	if start+tc.PieceLength > tc.TotalLength {
+		return tc.TotalLength - start
+	}
+	return tc.PieceLength
+}
+
+// MarkPieceVerified records a piece that passed the hash check and reports
+// whether it was new, the Done channel closes once every piece is verified.
+func (tc *TorrentClient) MarkPieceVerified(index int) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if index < 0 || index >= tc.TotalPieces || tc.OwnBitfield[index] {
+		return false
+	}
+	tc.OwnBitfield[index] = true
+	tc.Pieces[index].State = Verified
+	tc.Pieces[index].IsVerified = true
+	delete(tc.Downloading, index)
 
-	// so for getting the rarest piece we have to check in the peers list that which peers have the rarest piece
+	tc.checkDone()
+	return true
+}
+
+// SelectPieces limits the download to the pieces set in wanted, e.g. the
+// ones holding the files a magnet link's so asks for. The torrent is done
+// once those are verified. Calling it before InitPieces keeps anything from
+// being requested in between, InitPieces drops a selection of the wrong
+// length.
+func (tc *TorrentClient) SelectPieces(wanted []bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.selected = append([]bool(nil), wanted...)
+	if tc.metadata {
+		if len(tc.selected) != tc.TotalPieces {
+			tc.selected = nil
+			return
+		}
+		tc.checkDone()
+	}
+}
+
+// wanted must be called with tc.mu held
+func (tc *TorrentClient) wanted(index int) bool {
+	return tc.selected == nil || tc.selected[index]
+}
+
+// checkDone must be called with tc.mu held, it closes the done channel once
+// every wanted piece is verified.
+func (tc *TorrentClient) checkDone() {
+	for i, have := range tc.OwnBitfield {
+		if !have && tc.wanted(i) {
+			return
+		}
+	}
+	// the choker ranks peers for seeding from now on
+	tc.IsSeeder = true
+	if tc.done == nil {
+		tc.done = make(chan struct{})
+	}
+	tc.doneOnce.Do(func() { close(tc.done) })
+}
+
+// Done is closed when the last wanted piece has been verified.
+func (tc *TorrentClient) Done() <-chan struct{} {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	// created here as well so a magnet torrent can be waited on before InitPieces
+	if tc.done == nil {
+		tc.done = make(chan struct{})
+	}
+	return tc.done
+}
+
+// SetPeerBitfield replaces what we know the peer has and moves the piece
+// availability counts along with it.
+func (tc *TorrentClient) SetPeerBitfield(peer *Peer, bits []bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	peer.mu.Lock()
+	old := peer.Bitfield
+	peer.Bitfield = bits
+	peer.mu.Unlock()
+
+	tc.adjustAvailability(old, -1)
+	tc.adjustAvailability(bits, 1)
+}
+
+// PeerHasPiece records a HAVE, the bitfield is allocated for peers that
+// never sent one.
+func (tc *TorrentClient) PeerHasPiece(peer *Peer, index int) error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if index < 0 || index >= tc.TotalPieces {
+		return fmt.Errorf("piece index %d out of range", index)
+	}
+	peer.mu.Lock()
+	if len(peer.Bitfield) < tc.TotalPieces {
+		bits := make([]bool, tc.TotalPieces)
+		copy(bits, peer.Bitfield)
+		peer.Bitfield = bits
+	}
+	already := peer.Bitfield[index]
+	peer.Bitfield[index] = true
+	peer.mu.Unlock()
+
+	if !already {
+		tc.Pieces[index].Rarity++
+	}
+	return nil
+}
+
+// RemovePeerAvailability takes a disconnected peer's pieces out of the counts.
+func (tc *TorrentClient) RemovePeerAvailability(peer *Peer) {
+	tc.SetPeerBitfield(peer, nil)
+}
+
+// adjustAvailability must be called with tc.mu held
+func (tc *TorrentClient) adjustAvailability(bits []bool, delta int) {
+	for i, have := range bits {
+		if have && i < len(tc.Pieces) {
+			tc.Pieces[i].Rarity += delta
+		}
+	}
+}
 
+// RarestPiece picks, among the pieces the peer has and we still need, one
+// of those held by the fewest peers.
+func (tc *TorrentClient) RarestPiece(peer *Peer) (int, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return RarestFirstPicker{}.PickPiece(tc.pickContext(peer, nil))
 }
@@ -0,0 +1,188 @@
+package algorithms
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// RandomFirstPieces is how many pieces are picked at random before the
+// automatic picker switches to rarest first.
+const RandomFirstPieces = 4
+
+// PickContext is what a picker gets to look at, it is only valid during the
+// PickPiece call since the client lock is held while it runs.
+type PickContext struct {
+	Pieces []*Piece
+	Have   []bool
+	Peer   *Peer
+	// Partial are pieces already in progress that the peer has and that
+	// still have blocks nobody was asked for, lowest index first
+	Partial []int
+	// Allowed, when set, are the only pieces that may be requested, the
+	// peer chokes us and only lets us have its allowed fast pieces
+	Allowed map[int]bool
+	// Selected, when set, marks the pieces the user wants at all
+	Selected []bool
+}
+
+// Wanted reports whether index is a new piece worth starting from this peer.
+func (pc *PickContext) Wanted(index int) bool {
+	if pc.Allowed != nil && !pc.Allowed[index] {
+		return false
+	}
+	if pc.Selected != nil && !pc.Selected[index] {
+		return false
+	}
+	return !pc.Have[index] && pc.Pieces[index].State == NotRequested && pc.Peer.HasPiece(index)
+}
+
+// PiecePicker decides which piece to request blocks of next. Returning a
+// piece from Partial continues it, any other piece is started fresh.
+type PiecePicker interface {
+	PickPiece(pc *PickContext) (int, bool)
+}
+
+// RandomPicker picks any wanted piece, used for the first pieces so a new
+// peer gets something to share as soon as possible.
+type RandomPicker struct{}
+
+func (RandomPicker) PickPiece(pc *PickContext) (int, bool) {
+	var candidates []int
+	for i := range pc.Pieces {
+		if pc.Wanted(i) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, false
+	}
+	return candidates[rand.Intn(len(candidates))], true
+}
+
+// RarestFirstPicker picks one of the wanted pieces held by the fewest peers,
+// ties are broken randomly so peers starting at the same time don't all go
+// for the same piece.
+type RarestFirstPicker struct{}
+
+func (RarestFirstPicker) PickPiece(pc *PickContext) (int, bool) {
+	// so for getting the rarest piece we have to check in the peers list that which peers have the rarest piece
+	var candidates []int
+	lowest := 0
+	for i, piece := range pc.Pieces {
+		if !pc.Wanted(i) {
+			continue
+		}
+		switch {
+		case len(candidates) == 0 || piece.Rarity < lowest:
+			candidates = append(candidates[:0], i)
+			lowest = piece.Rarity
+		case piece.Rarity == lowest:
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, false
+	}
+	return candidates[rand.Intn(len(candidates))], true
+}
+
+// StrictPriorityPicker finishes pieces that are already in progress before
+// letting Next start a new one.
+type StrictPriorityPicker struct {
+	Next PiecePicker
+}
+
+func (p StrictPriorityPicker) PickPiece(pc *PickContext) (int, bool) {
+	if len(pc.Partial) > 0 {
+		return pc.Partial[0], true
+	}
+	if p.Next == nil {
+		return 0, false
+	}
+	return p.Next.PickPiece(pc)
+}
+
+// SequentialPicker goes in piece order, handy for streaming.
+type SequentialPicker struct{}
+
+func (SequentialPicker) PickPiece(pc *PickContext) (int, bool) {
+	for i := range pc.Pieces {
+		if pc.Wanted(i) || (len(pc.Partial) > 0 && pc.Partial[0] == i) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// picker must be called with tc.mu held. Without a caller supplied Picker
+// the policy follows piece-algo.go: random for the first pieces, then
+// strict priority on top of rarest first.
+func (tc *TorrentClient) picker() PiecePicker {
+	if tc.Picker != nil {
+		return tc.Picker
+	}
+
+	if tc.endgame {
+		return StrictPriorityPicker{}
+	}
+
+	verified := 0
+	for _, have := range tc.OwnBitfield {
+		if have {
+			verified++
+		}
+	}
+	if verified < RandomFirstPieces {
+		tc.Strategy = "random"
+		return StrictPriorityPicker{Next: RandomPicker{}}
+	}
+	tc.Strategy = "rarest"
+	return StrictPriorityPicker{Next: RarestFirstPicker{}}
+}
+
+// pickContext must be called with tc.mu held
+func (tc *TorrentClient) pickContext(peer *Peer, allowed map[int]bool) *PickContext {
+	pc := &PickContext{Pieces: tc.Pieces, Have: tc.OwnBitfield, Peer: peer, Allowed: allowed, Selected: tc.selected}
+	for index, pp := range tc.inProgress {
+		if allowed != nil && !allowed[index] {
+			continue
+		}
+		if pp.freeBlock() >= 0 && peer.HasPiece(index) {
+			pc.Partial = append(pc.Partial, index)
+		}
+	}
+	sort.Ints(pc.Partial)
+	return pc
+}
+
+// pickPiece must be called with tc.mu held. A piece the peer suggested goes
+// first, then whatever the picker returns is checked and a piece we can't
+// request from this peer falls back to finishing a partial one.
+func (tc *TorrentClient) pickPiece(peer *Peer, allowed map[int]bool) (int, bool) {
+	pc := tc.pickContext(peer, allowed)
+	if !tc.endgame {
+		peer.mu.Lock()
+		suggested := append([]int(nil), peer.suggested...)
+		peer.mu.Unlock()
+		for _, index := range suggested {
+			if index >= 0 && index < len(tc.Pieces) && pc.Wanted(index) {
+				return index, true
+			}
+		}
+	}
+	index, ok := tc.picker().PickPiece(pc)
+	if ok && index >= 0 && index < len(tc.Pieces) {
+		if pc.Wanted(index) {
+			return index, true
+		}
+		for _, p := range pc.Partial {
+			if p == index {
+				return index, true
+			}
+		}
+	}
+	if len(pc.Partial) > 0 {
+		return pc.Partial[0], true
+	}
+	return 0, false
+}
@@ -0,0 +1,136 @@
+package algorithms
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const handshakeTimeout = 10 * time.Second
+
+// Session holds every torrent the client is running so one listener can
+// serve them all, incoming connections are routed by the info hash in the
+// peer's handshake.
+type Session struct {
+	PeerID string
+
+	mu       sync.Mutex
+	torrents map[[20]byte]*TorrentClient
+	wg       sync.WaitGroup
+}
+
+func NewSession(peerID string) *Session {
+	return &Session{
+		PeerID:   peerID,
+		torrents: make(map[[20]byte]*TorrentClient),
+	}
+}
+
+func (s *Session) AddTorrent(tc *TorrentClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tc.PeerID == "" {
+		tc.PeerID = s.PeerID
+	}
+	s.torrents[tc.InfoHash] = tc
+}
+
+func (s *Session) RemoveTorrent(infoHash [20]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.torrents, infoHash)
+}
+
+func (s *Session) Torrent(infoHash [20]byte) (*TorrentClient, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tc, ok := s.torrents[infoHash]
+	return tc, ok
+}
+
+// Serve accepts connections until the listener is closed.
+func (s *Session) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.wg.Wait()
+				return nil
+			}
+			return err
+		}
+		// added here and not in the goroutine, Wait must not miss it
+		s.wg.Add(1)
+		go s.handleInbound(conn)
+	}
+}
+
+// handleInbound reads the remote handshake first, we only answer once we
+// know which torrent it is for and that it isn't our own connection.
+func (s *Session) handleInbound(conn net.Conn) {
+	defer s.wg.Done()
+	addr := conn.RemoteAddr().String()
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	hs, err := ReadHandshake(conn)
+	if err != nil {
+		log.Printf("❌ Bad handshake from %s: %v", addr, err)
+		conn.Close()
+		return
+	}
+	tc, ok := s.Torrent(hs.InfoHash)
+	if !ok {
+		log.Printf("❌ %s asked for an unknown torrent", addr)
+		conn.Close()
+		return
+	}
+	if hs.PeerID == peerIDBytes(tc.PeerID) {
+		conn.Close()
+		return
+	}
+	if err := WriteHandshake(conn, &Handshake{Reserved: tc.reserved(), InfoHash: tc.InfoHash, PeerID: peerIDBytes(tc.PeerID)}); err != nil {
+		log.Printf("❌ Failed to answer handshake from %s: %v", addr, err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	peer, err := tc.acceptPeer(conn, hs)
+	if err != nil {
+		log.Printf("❌ Rejected %s: %v", addr, err)
+		conn.Close()
+		return
+	}
+	log.Printf("🔗 Accepted peer %s", addr)
+
+	// handleInbound still holds its own count, so this Add can't race Wait
+	s.wg.Add(1)
+	tc.PeerLoop(conn, peer, &s.wg)
+}
+
+// acceptPeer registers an incoming connection in tc.Peers, a peer we are
+// already talking to under the same address is refused.
+func (tc *TorrentClient) acceptPeer(conn net.Conn, hs *Handshake) (*Peer, error) {
+	tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("not a tcp connection")
+	}
+	peer := &Peer{
+		IP:              tcpAddr.IP,
+		PORT:            uint16(tcpAddr.Port),
+		Conn:            conn,
+		Choked:          true,
+		LastCheckedTime: time.Now(),
+		ID:              hs.PeerID,
+		Reserved:        hs.Reserved,
+		Inbound:         true,
+	}
+	key := fmt.Sprintf("%s:%d", peer.IP, peer.PORT)
+	if added := tc.AddPeers(map[string]*Peer{key: peer}); len(added) == 0 {
+		return nil, fmt.Errorf("already connected")
+	}
+	return peer, nil
+}
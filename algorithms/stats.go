@@ -0,0 +1,114 @@
+package algorithms
+
+import (
+	"time"
+)
+
+// RecordDownload counts payload bytes received from a peer, it feeds both
+// the choker's rate window and the totals reported to the tracker.
+func (tc *TorrentClient) RecordDownload(peer *Peer, n int) {
+	tc.downloaded.Add(int64(n))
+	peer.mu.Lock()
+	peer.BytesDownloaded += n
+	peer.Downloaded += int64(n)
+	peer.mu.Unlock()
+}
+
+// RecordUpload counts payload bytes we sent to a peer.
+func (tc *TorrentClient) RecordUpload(peer *Peer, n int) {
+	tc.uploaded.Add(int64(n))
+	peer.mu.Lock()
+	peer.Uploaded += int64(n)
+	peer.mu.Unlock()
+}
+
+func (tc *TorrentClient) BytesDownloaded() int64 {
+	return tc.downloaded.Load()
+}
+
+func (tc *TorrentClient) BytesUploaded() int64 {
+	return tc.uploaded.Load()
+}
+
+// BytesLeft is what the tracker calls left, the bytes of every wanted piece
+// we have not verified yet.
+func (tc *TorrentClient) BytesLeft() int64 {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	var left int64
+	for i, have := range tc.OwnBitfield {
+		if !have && tc.wanted(i) {
+			left += tc.PieceSize(i)
+		}
+	}
+	return left
+}
+
+// AddPeers merges peers from a tracker or another source into tc.Peers,
+// peers we already know about keep their existing state. It returns the
+// ones that were actually new.
+func (tc *TorrentClient) AddPeers(peers map[string]*Peer) map[string]*Peer {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.Peers == nil {
+		tc.Peers = make(map[string]*Peer)
+	}
+	added := make(map[string]*Peer)
+	for key, peer := range peers {
+		if _, ok := tc.Peers[key]; ok {
+			continue
+		}
+		if peer.LastCheckedTime.IsZero() {
+			peer.LastCheckedTime = time.Now()
+		}
+		tc.Peers[key] = peer
+		added[key] = peer
+	}
+	return added
+}
+
+func (tc *TorrentClient) RemovePeer(key string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	delete(tc.Peers, key)
+}
+
+// Peer looks a peer up by its tc.Peers key.
+func (tc *TorrentClient) Peer(key string) (*Peer, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	peer, ok := tc.Peers[key]
+	return peer, ok
+}
+
+// DropPeer forgets the peer and closes its connection if it has one.
+func (tc *TorrentClient) DropPeer(peer *Peer) {
+	tc.forgetPeer(peer)
+	peer.close()
+}
+
+// forgetPeer takes a peer out of tc.Peers once its connection failed or
+// ended, so the next announce or peer exchange that lists it adds it again.
+func (tc *TorrentClient) forgetPeer(peer *Peer) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	for key, p := range tc.Peers {
+		if p == peer {
+			delete(tc.Peers, key)
+			return
+		}
+	}
+}
+
+// PeerList is a snapshot of tc.Peers that can be walked without holding the lock.
+func (tc *TorrentClient) PeerList() []*Peer {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	peers := make([]*Peer, 0, len(tc.Peers))
+	for _, peer := range tc.Peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
@@ -0,0 +1,196 @@
+package algorithms
+
+import (
+	"log"
+)
+
+const (
+	// some clients ask for more than 16 KiB, anything past this is refused
+	MaxUploadRequestLength = 128 * 1024
+	// requests queued per peer before new ones are refused
+	MaxUploadQueue = 256
+)
+
+// HavePiece reports whether we have verified the piece.
+func (tc *TorrentClient) HavePiece(index int) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return index >= 0 && index < len(tc.OwnBitfield) && tc.OwnBitfield[index]
+}
+
+// handleRequest queues a REQUEST for the uploader, requests we can't or
+// won't serve are refused right here, with a REJECT_REQUEST for fast peers.
+func (tc *TorrentClient) handleRequest(peer *Peer, msg *Message) {
+	req, err := ParseRequest(msg)
+	if err != nil {
+		return
+	}
+	if !tc.validUploadRequest(req) {
+		log.Printf("🚫 Ignoring invalid request %+v from %s", req, peer.IP)
+		tc.rejectRequest(peer, req)
+		return
+	}
+
+	peer.mu.Lock()
+	// BEP 3 says requests from a choked peer are discarded, its allowed fast pieces are the exception
+	refuse := (peer.Choked && !peer.allowedFastSent[req.Index]) || len(peer.uploadQueue) >= MaxUploadQueue
+	duplicate := false
+	for _, queued := range peer.uploadQueue {
+		if queued == req {
+			duplicate = true
+			break
+		}
+	}
+	if !refuse && !duplicate {
+		peer.uploadQueue = append(peer.uploadQueue, req)
+		peer.signalUpload()
+	}
+	peer.mu.Unlock()
+
+	if refuse {
+		tc.rejectRequest(peer, req)
+	}
+}
+
+func (tc *TorrentClient) validUploadRequest(req BlockRequest) bool {
+	if req.Length <= 0 || req.Length > MaxUploadRequestLength || req.Begin < 0 {
+		return false
+	}
+	if !tc.HavePiece(req.Index) {
+		return false
+	}
+	return int64(req.Begin)+int64(req.Length) <= tc.PieceSize(req.Index)
+}
+
+// handleCancel drops a queued request, one that is already being sent goes
+// out anyway. The fast extension wants every cancelled request answered, so
+// a fast peer gets a REJECT_REQUEST for it.
+func (tc *TorrentClient) handleCancel(peer *Peer, msg *Message) {
+	req, err := ParseCancel(msg)
+	if err != nil {
+		return
+	}
+	peer.mu.Lock()
+	cancelled := false
+	for i, queued := range peer.uploadQueue {
+		if queued == req {
+			peer.uploadQueue = append(peer.uploadQueue[:i], peer.uploadQueue[i+1:]...)
+			cancelled = true
+			break
+		}
+	}
+	peer.mu.Unlock()
+
+	if cancelled {
+		tc.rejectRequest(peer, req)
+	}
+}
+
+// signalUpload must be called with peer.mu held
+func (p *Peer) signalUpload() {
+	if p.uploadReady == nil {
+		p.uploadReady = make(chan struct{}, 1)
+	}
+	select {
+	case p.uploadReady <- struct{}{}:
+	default:
+	}
+}
+
+// serveUploads sends the queued blocks until stop is closed, it runs next
+// to PeerLoop so a slow disk read never holds up reading messages.
+func (tc *TorrentClient) serveUploads(peer *Peer, stop <-chan struct{}) {
+	peer.mu.Lock()
+	if peer.uploadReady == nil {
+		peer.uploadReady = make(chan struct{}, 1)
+	}
+	ready := peer.uploadReady
+	peer.mu.Unlock()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ready:
+		}
+
+		for {
+			peer.mu.Lock()
+			if len(peer.uploadQueue) == 0 {
+				peer.uploadQueue = nil
+				peer.mu.Unlock()
+				break
+			}
+			req := peer.uploadQueue[0]
+			peer.uploadQueue = peer.uploadQueue[1:]
+			// only allowed fast pieces are still served once the peer is choked
+			skip := peer.Choked && !peer.allowedFastSent[req.Index]
+			peer.mu.Unlock()
+			if skip {
+				continue
+			}
+
+			if err := tc.uploadBlock(peer, req); err != nil {
+				log.Printf("❌ Failed to upload %+v to %s: %v", req, peer.IP, err)
+				// nothing would drain the queue any more, PeerLoop cleans up
+				peer.close()
+				return
+			}
+		}
+	}
+}
+
+func (tc *TorrentClient) uploadBlock(peer *Peer, req BlockRequest) error {
+	if tc.Storage == nil {
+		return nil
+	}
+	block := make([]byte, req.Length)
+	if _, err := tc.Storage.ReadAt(req.Index, block, int64(req.Begin)); err != nil {
+		return err
+	}
+	if err := peer.Send(FormatPiece(req.Index, req.Begin, block)); err != nil {
+		return err
+	}
+	tc.RecordUpload(peer, len(block))
+	return nil
+}
+
+// SetChoked chokes or unchokes a peer and tells it so, choking throws away
+// whatever the peer had queued. A fast peer keeps its allowed fast requests
+// and gets a REJECT_REQUEST for each of the others, so it can ask someone
+// else right away instead of waiting for blocks that never come.
+func (tc *TorrentClient) SetChoked(peer *Peer, choked bool) {
+	fast := peer.SupportsFast()
+	peer.mu.Lock()
+	changed := peer.Choked != choked
+	peer.Choked = choked
+	var rejected []BlockRequest
+	if choked {
+		var kept []BlockRequest
+		for _, req := range peer.uploadQueue {
+			if fast && peer.allowedFastSent[req.Index] {
+				kept = append(kept, req)
+			} else {
+				rejected = append(rejected, req)
+			}
+		}
+		peer.uploadQueue = kept
+	}
+	connected := peer.Conn != nil
+	peer.mu.Unlock()
+
+	if !changed || !connected {
+		return
+	}
+	id := MsgUnchoke
+	if choked {
+		id = MsgChoke
+	}
+	if err := peer.Send(NewMessage(id, nil)); err != nil {
+		log.Printf("❌ Failed to send choke state to %s: %v", peer.IP, err)
+		return
+	}
+	for _, req := range rejected {
+		tc.rejectRequest(peer, req)
+	}
+}
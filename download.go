@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"torrent-client/algorithms"
+	"torrent-client/extensions"
+	"torrent-client/metainfo"
+	"torrent-client/storage"
+	"torrent-client/tracker"
+	"torrent-client/utils"
+)
+
+const port = 6881
+
+// runDownload downloads a torrent into the output directory and keeps
+// seeding it until interrupted. The torrent is a .torrent file or a magnet
+// link, the latter starts without metadata.
+func runDownload(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: torrent-client download <file.torrent|magnet link> [output dir]")
+	}
+	outDir := "."
+	if len(args) > 1 {
+		outDir = args[1]
+	}
+
+	var meta *metainfo.TorrentMeta
+	var magnet *metainfo.Magnet
+	var err error
+	if strings.HasPrefix(args[0], "magnet:") {
+		magnet, err = metainfo.ParseMagnet(args[0])
+		if err != nil {
+			return err
+		}
+	} else {
+		// This part is reading  the torrent file and after reading the file it extract the piece hashes and after that I instantiate the client and pass pieces in to the client
+		meta, err = metainfo.Load(args[0])
+		if err != nil {
+			return fmt.Errorf("error reading torrent file: %w", err)
+		}
+	}
+
+	peerID := utils.GeneratePeerID()
+	var client *algorithms.TorrentClient
+	var protocol *extensions.Protocol
+	var tiers [][]string
+	if magnet != nil {
+		client = newTorrentClient(magnet.InfoHash, peerID)
+		protocol = extensions.NewProtocol(client)
+		protocol.OnMetadata = func(meta *metainfo.TorrentMeta) error {
+			if err := loadMetadata(client, meta, outDir, magnet.SelectOnly); err != nil {
+				return err
+			}
+			fmt.Printf("✅ Torrent '%s' loaded with %d pieces.\n", meta.Info.Name, client.TotalPieces)
+			return nil
+		}
+		tiers = magnet.AnnounceTiers()
+		fmt.Printf("🧲 Starting '%s' from magnet link, waiting for metadata\n", magnet.DisplayName)
+	} else {
+		client = newTorrentClient(meta.InfoHash, peerID)
+		protocol = extensions.NewProtocol(client)
+		protocol.SetMetadata(meta)
+		tiers = meta.AnnounceTiers()
+		if err := loadMetadata(client, meta, outDir, nil); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Torrent '%s' loaded with %d pieces.\n", meta.Info.Name, client.TotalPieces)
+	}
+	protocol.ListenPort = port
+	defer func() {
+		if client.Storage != nil {
+			client.Storage.Close()
+		}
+	}()
+
+	session := algorithms.NewSession(peerID)
+	session.AddTorrent(client)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+	defer listener.Close()
+	fmt.Printf("🌐 Torrent client listening on %s\n", listener.Addr())
+	go session.Serve(listener)
+
+	startChoker(client)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var wg sync.WaitGroup
+	connect := func(peers map[string]*algorithms.Peer) {
+		for _, peer := range peers {
+			// the map keys don't bracket IPv6 addresses, so they can't be dialed
+			address := net.JoinHostPort(peer.IP.String(), strconv.Itoa(int(peer.PORT)))
+			wg.Add(1)
+			go client.ConnectToPeer(peer, address, client.InfoHash, peerID, &wg)
+		}
+	}
+	protocol.OnNewPeers = connect
+	if magnet != nil {
+		connect(client.AddPeers(peersFromAddrs(magnet.Peers)))
+	}
+
+	announcer := &tracker.Announcer{
+		Trackers:   tracker.NewTierList(tiers),
+		Client:     client,
+		InfoHash:   client.InfoHash,
+		PeerID:     peerID,
+		Port:       port,
+		OnNewPeers: connect,
+	}
+	announced := make(chan struct{})
+	go func() {
+		announcer.Run(ctx)
+		close(announced)
+	}()
+
+	select {
+	case <-client.Done():
+		fmt.Println("✅ Download complete, seeding until interrupted")
+		<-ctx.Done()
+	case <-ctx.Done():
+	}
+	<-announced
+	return nil
+}
+
+// loadMetadata sets the client up for the torrent's pieces and opens its
+// files, for a magnet link this happens once the metadata is known.
+// selectOnly limits the download to those files, nil means all of them.
+func loadMetadata(client *algorithms.TorrentClient, meta *metainfo.TorrentMeta, outDir string, selectOnly []int) error {
+	hashes, err := meta.Pieces()
+	if err != nil {
+		return fmt.Errorf("invalid piece hashes: %w", err)
+	}
+	if selectOnly != nil {
+		wanted := meta.FilePieces(selectOnly)
+		if slices.Contains(wanted, true) {
+			client.SelectPieces(wanted)
+		} else {
+			// retrying can't fix the selection, so it is dropped rather than failing the metadata
+			log.Printf("❌ so selects none of the %d files, downloading all of them", len(meta.Info.FileList()))
+		}
+	}
+	store, err := storage.NewFileStorage(outDir, storageFiles(meta), meta.Info.PieceLength)
+	if err != nil {
+		return err
+	}
+	client.PieceLength = meta.Info.PieceLength
+	client.TotalLength = meta.Info.TotalLength()
+	client.Storage = store
+	client.InitPieces(hashes)
+	return nil
+}
+
+// peersFromAddrs turns host:port strings, like the x.pe addresses of a
+// magnet link, into peers keyed the way utils.ParsePeers keys them.
+func peersFromAddrs(addrs []string) map[string]*algorithms.Peer {
+	peers := make(map[string]*algorithms.Peer)
+	for _, addr := range addrs {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			log.Printf("❌ Skipping peer address %q: %v", addr, err)
+			continue
+		}
+		key := fmt.Sprintf("%s:%d", tcpAddr.IP, tcpAddr.Port)
+		peers[key] = &algorithms.Peer{IP: tcpAddr.IP, PORT: uint16(tcpAddr.Port)}
+	}
+	return peers
+}
+
+func newTorrentClient(infoHash [20]byte, peerID string) *algorithms.TorrentClient {
+	return &algorithms.TorrentClient{
+		InfoHash:                     infoHash,
+		PeerID:                       peerID,
+		Peers:                        make(map[string]*algorithms.Peer),
+		UnchokeInterval:              10 * time.Second,
+		OptimisticInterval:           30 * time.Second,
+		DownloadRateCheckingInterval: 10 * time.Second,
+		SnubbedCheckingInterval:      time.Minute,
+	}
+}
+
+func startChoker(client *algorithms.TorrentClient) {
+	go client.RunCheckLoop()
+	go client.UpdateDownloadRateOfPeers()
+	client.SnubberChecker()
+}
+
+// storageFiles lays the torrent out on disk, a multi-file torrent goes into
+// a directory named after info.name.
+func storageFiles(meta *metainfo.TorrentMeta) []storage.File {
+	var files []storage.File
+	for _, f := range meta.Info.FileList() {
+		path := f.Path
+		if meta.Info.IsMultiFile() {
+			path = append([]string{meta.Info.Name}, f.Path...)
+		}
+		files = append(files, storage.File{Path: path, Length: f.Length})
+	}
+	return files
+}
@@ -0,0 +1,415 @@
+package extensions
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"torrent-client/algorithms"
+	"torrent-client/metainfo"
+	"torrent-client/utils"
+)
+
+const (
+	// the info dictionary is exchanged in 16 KiB pieces, only the last one is shorter
+	metadataPieceSize = 16 * 1024
+	// MaxMetadataSize is the largest info dictionary we are willing to download
+	MaxMetadataSize        = 8 << 20
+	metadataRequestTimeout = 30 * time.Second
+	// spreading the pieces over several peers is faster and limits what one bad peer can spoil
+	metadataRequestsPerPeer = 2
+)
+
+// ut_metadata msg_type values
+const (
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+)
+
+// metadataMessage is the bencoded head of every ut_metadata message, a data
+// message has the piece bytes right after it.
+type metadataMessage struct {
+	MsgType   int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
+type metadataPieceRequest struct {
+	peer *algorithms.Peer
+	sent time.Time
+}
+
+// metadataDownload assembles the info dictionary, every field is guarded by
+// metadataExtension.mu.
+type metadataDownload struct {
+	size     int
+	pieces   [][]byte
+	requests map[int]metadataPieceRequest
+	// who sent each piece, they are blamed when the hash doesn't match
+	sources map[int]*algorithms.Peer
+}
+
+func (d *metadataDownload) start(size int) {
+	d.size = size
+	d.pieces = make([][]byte, (size+metadataPieceSize-1)/metadataPieceSize)
+	d.requests = make(map[int]metadataPieceRequest)
+	d.sources = make(map[int]*algorithms.Peer)
+}
+
+func (d *metadataDownload) reset() {
+	*d = metadataDownload{}
+}
+
+func (d *metadataDownload) pieceLength(piece int) int {
+	if piece == len(d.pieces)-1 {
+		return d.size - piece*metadataPieceSize
+	}
+	return metadataPieceSize
+}
+
+func (d *metadataDownload) complete() bool {
+	for _, piece := range d.pieces {
+		if piece == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *metadataDownload) outstanding(peer *algorithms.Peer) int {
+	n := 0
+	for _, r := range d.requests {
+		if r.peer == peer {
+			n++
+		}
+	}
+	return n
+}
+
+// dropPeer gives the peer's requests back so someone else is asked.
+func (d *metadataDownload) dropPeer(peer *algorithms.Peer) {
+	for piece, r := range d.requests {
+		if r.peer == peer {
+			delete(d.requests, piece)
+		}
+	}
+}
+
+// metadataPeer is what the metadata exchange knows about one peer.
+type metadataPeer struct {
+	supported bool
+	size      int
+	// set once the peer rejected a request or sent bad metadata
+	bad bool
+}
+
+// metadataExtension is ut_metadata (BEP 9), it downloads the info
+// dictionary while the client has none and serves it once there is one.
+type metadataExtension struct {
+	protocol *Protocol
+
+	mu sync.Mutex
+	// info is the verified info dictionary we serve to peers, it is only
+	// set once OnMetadata accepted it
+	info []byte
+	// loading is set while OnMetadata runs, nothing is requested meanwhile
+	loading bool
+	// retrying is set while a timer will call request again
+	retrying bool
+	peers    map[*algorithms.Peer]*metadataPeer
+	download metadataDownload
+}
+
+func newMetadataExtension(protocol *Protocol) *metadataExtension {
+	return &metadataExtension{
+		protocol: protocol,
+		peers:    make(map[*algorithms.Peer]*metadataPeer),
+	}
+}
+
+func (m *metadataExtension) setInfo(info []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.info = info
+}
+
+func (m *metadataExtension) FillHandshake(hs *Handshake) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hs.MetadataSize = len(m.info)
+}
+
+func (m *metadataExtension) PeerHandshake(peer *algorithms.Peer, hs *Handshake) error {
+	// a later handshake may leave ut_metadata out, the merged ids still have it
+	supported := m.protocol.Supports(peer, "ut_metadata")
+
+	m.mu.Lock()
+	state, ok := m.peers[peer]
+	if !ok {
+		state = &metadataPeer{}
+		m.peers[peer] = state
+	}
+	state.supported = supported
+	if hs.MetadataSize > 0 {
+		state.size = hs.MetadataSize
+	}
+	m.mu.Unlock()
+	m.request()
+	return nil
+}
+
+func (m *metadataExtension) PeerDisconnected(peer *algorithms.Peer) {
+	m.mu.Lock()
+	delete(m.peers, peer)
+	m.download.dropPeer(peer)
+	m.mu.Unlock()
+	m.request()
+}
+
+func (m *metadataExtension) HandleMessage(peer *algorithms.Peer, payload []byte) error {
+	parser := utils.NewParser(payload)
+	var msg metadataMessage
+	if err := parser.Unmarshal(&msg); err != nil {
+		return fmt.Errorf("invalid ut_metadata message: %w", err)
+	}
+
+	switch msg.MsgType {
+	case metadataRequest:
+		return m.serve(peer, msg.Piece)
+	case metadataData:
+		m.receive(peer, &msg, payload[parser.Offset():])
+	case metadataReject:
+		m.mu.Lock()
+		if r, ok := m.download.requests[msg.Piece]; ok && r.peer == peer {
+			delete(m.download.requests, msg.Piece)
+		}
+		if state, ok := m.peers[peer]; ok {
+			state.bad = true
+		}
+		m.mu.Unlock()
+		m.request()
+	}
+	// unknown msg_type values are ignored as BEP 9 asks
+	return nil
+}
+
+// serve answers a request with the piece or, while we don't have the
+// metadata ourselves, a reject.
+func (m *metadataExtension) serve(peer *algorithms.Peer, piece int) error {
+	m.mu.Lock()
+	info := m.info
+	m.mu.Unlock()
+
+	var payload []byte
+	var err error
+	// checked before multiplying, a huge piece would overflow into a negative offset
+	if info == nil || piece < 0 || piece >= (len(info)+metadataPieceSize-1)/metadataPieceSize {
+		payload, err = utils.MarshalBencode(metadataMessage{MsgType: metadataReject, Piece: piece})
+	} else {
+		start := piece * metadataPieceSize
+		payload, err = utils.MarshalBencode(metadataMessage{MsgType: metadataData, Piece: piece, TotalSize: len(info)})
+		payload = append(payload, info[start:min(start+metadataPieceSize, len(info))]...)
+	}
+	if err != nil {
+		return err
+	}
+	if err := m.protocol.Send(peer, "ut_metadata", payload); err != nil && !errors.Is(err, ErrNotSupported) {
+		return err
+	}
+	// a peer that never told us its ut_metadata id gets no answer
+	return nil
+}
+
+// receive stores a piece we asked the peer for, the last one completes the
+// dictionary and it is checked against the info hash.
+func (m *metadataExtension) receive(peer *algorithms.Peer, msg *metadataMessage, data []byte) {
+	m.mu.Lock()
+	d := &m.download
+	r, requested := d.requests[msg.Piece]
+	if m.info != nil || !requested || r.peer != peer {
+		m.mu.Unlock()
+		return
+	}
+	delete(d.requests, msg.Piece)
+	if msg.TotalSize != d.size || len(data) != d.pieceLength(msg.Piece) {
+		log.Printf("❌ Peer %s sent a metadata piece of the wrong size", peer.IP)
+		if state, ok := m.peers[peer]; ok {
+			state.bad = true
+		}
+		m.mu.Unlock()
+		m.request()
+		return
+	}
+	d.pieces[msg.Piece] = append([]byte(nil), data...)
+	d.sources[msg.Piece] = peer
+	if !d.complete() {
+		m.mu.Unlock()
+		m.request()
+		return
+	}
+
+	info := bytes.Join(d.pieces, nil)
+	hash := sha1.Sum(info)
+	var meta *metainfo.TorrentMeta
+	var err error
+	if hash != m.protocol.Client.InfoHash {
+		err = fmt.Errorf("info hash mismatch")
+	} else {
+		meta, err = metainfo.ParseInfo(info)
+	}
+	if err != nil {
+		log.Printf("❌ Fetched metadata is bad: %v", err)
+		// we can't tell which piece was wrong, so nobody who sent one is asked again
+		for _, source := range d.sources {
+			if state, ok := m.peers[source]; ok {
+				state.bad = true
+			}
+		}
+		d.reset()
+		m.mu.Unlock()
+		m.request()
+		return
+	}
+	m.loading = true
+	d.reset()
+	m.mu.Unlock()
+
+	log.Printf("🧲 Metadata for '%s' received (%d bytes)", meta.Info.Name, len(info))
+	if m.protocol.OnMetadata != nil {
+		if err := m.protocol.OnMetadata(meta); err != nil {
+			log.Printf("❌ Failed to start the download from the metadata: %v", err)
+			m.mu.Lock()
+			m.loading = false
+			// fetch it again in a while, whatever failed (a full disk, say) may be fixed by then
+			m.scheduleRetry()
+			m.mu.Unlock()
+			return
+		}
+	}
+	m.mu.Lock()
+	m.info = info
+	m.loading = false
+	m.mu.Unlock()
+	// only now, peer exchange stays off for metadata OnMetadata turned down
+	m.protocol.setPrivate(meta.Info.Private == 1)
+}
+
+// request hands out the missing pieces to peers that have the metadata,
+// pieces that timed out go to someone else.
+func (m *metadataExtension) request() {
+	type request struct {
+		peer    *algorithms.Peer
+		payload []byte
+	}
+	var requests []request
+
+	m.mu.Lock()
+	d := &m.download
+	if m.info != nil || m.loading {
+		m.mu.Unlock()
+		return
+	}
+	if d.size != 0 && !m.sizeAvailable() {
+		// whoever told us this size is gone or turned bad, it may have lied
+		d.reset()
+	}
+	if d.size == 0 {
+		// the size comes from the first usable peer, bad data resets it
+		for _, state := range m.peers {
+			if state.usable() && state.size <= MaxMetadataSize {
+				d.start(state.size)
+				break
+			}
+		}
+		if d.size == 0 {
+			m.mu.Unlock()
+			return
+		}
+	}
+
+	now := time.Now()
+	for piece := range d.pieces {
+		if d.pieces[piece] != nil {
+			continue
+		}
+		last, pending := d.requests[piece]
+		if pending && now.Sub(last.sent) < metadataRequestTimeout {
+			continue
+		}
+		peer := m.pickPeer(last.peer)
+		if peer == nil && pending {
+			// nobody else has it, the peer that timed out may still answer
+			peer = m.pickPeer(nil)
+		}
+		if peer == nil {
+			break
+		}
+		payload, err := utils.MarshalBencode(metadataMessage{MsgType: metadataRequest, Piece: piece})
+		if err != nil {
+			break
+		}
+		d.requests[piece] = metadataPieceRequest{peer: peer, sent: now}
+		requests = append(requests, request{peer: peer, payload: payload})
+	}
+	// look again once requests could have timed out, a silent peer sends no
+	// event that would
+	m.scheduleRetry()
+	m.mu.Unlock()
+
+	for _, r := range requests {
+		if err := m.protocol.Send(r.peer, "ut_metadata", r.payload); err != nil {
+			log.Printf("❌ Failed to request metadata from %s: %v", r.peer.IP, err)
+		}
+	}
+}
+
+// scheduleRetry makes sure request runs again after metadataRequestTimeout,
+// one timer at a time. Must be called with m.mu held.
+func (m *metadataExtension) scheduleRetry() {
+	if m.retrying {
+		return
+	}
+	m.retrying = true
+	time.AfterFunc(metadataRequestTimeout, func() {
+		m.mu.Lock()
+		m.retrying = false
+		m.mu.Unlock()
+		m.request()
+	})
+}
+
+// sizeAvailable reports whether a usable peer still has the size being
+// downloaded. Must be called with m.mu held.
+func (m *metadataExtension) sizeAvailable() bool {
+	for _, state := range m.peers {
+		if state.usable() && state.size == m.download.size {
+			return true
+		}
+	}
+	return false
+}
+
+// pickPeer picks the peer with the fewest outstanding metadata requests,
+// skipping the one a request just timed out on. Must be called with m.mu
+// held.
+func (m *metadataExtension) pickPeer(skip *algorithms.Peer) *algorithms.Peer {
+	var best *algorithms.Peer
+	bestLoad := metadataRequestsPerPeer
+	for peer, state := range m.peers {
+		if peer == skip || !state.usable() || state.size != m.download.size {
+			continue
+		}
+		if load := m.download.outstanding(peer); load < bestLoad {
+			best, bestLoad = peer, load
+		}
+	}
+	return best
+}
+
+func (state *metadataPeer) usable() bool {
+	return state.supported && state.size > 0 && !state.bad
+}
@@ -0,0 +1,146 @@
+package extensions
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"testing"
+	"time"
+	"torrent-client/algorithms"
+	"torrent-client/utils"
+)
+
+// testPeer is a peer that told us its ut_metadata id, the test reads the
+// ut_metadata messages we send it.
+type testPeer struct {
+	peer *algorithms.Peer
+	msgs chan []byte
+}
+
+func newTestPeer(t *testing.T, p *Protocol, ip net.IP) *testPeer {
+	t.Helper()
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+
+	tp := &testPeer{
+		peer: &algorithms.Peer{IP: ip, Conn: local, HandshakeDone: true},
+		msgs: make(chan []byte, 16),
+	}
+	p.mu.Lock()
+	p.peers[tp.peer] = &peerState{ids: map[string]int{"ut_metadata": 3}}
+	p.mu.Unlock()
+
+	go func() {
+		for {
+			msg, err := algorithms.ReadMessage(remote)
+			if err != nil {
+				return
+			}
+			if _, payload, err := algorithms.ParseExtended(&msg); err == nil {
+				tp.msgs <- payload
+			}
+		}
+	}()
+	return tp
+}
+
+// next returns the next ut_metadata message and the data after its head.
+func (tp *testPeer) next(t *testing.T) (metadataMessage, []byte) {
+	t.Helper()
+	select {
+	case payload := <-tp.msgs:
+		parser := utils.NewParser(payload)
+		var msg metadataMessage
+		if err := parser.Unmarshal(&msg); err != nil {
+			t.Fatalf("bad ut_metadata message: %v", err)
+		}
+		return msg, payload[parser.Offset():]
+	case <-time.After(2 * time.Second):
+		t.Fatal("no ut_metadata message")
+	}
+	return metadataMessage{}, nil
+}
+
+func TestMetadataServe(t *testing.T) {
+	info := bytes.Repeat([]byte("x"), 2*metadataPieceSize+100)
+	p := NewProtocol(&algorithms.TorrentClient{})
+	p.metadata.setInfo(info)
+	tp := newTestPeer(t, p, net.IPv4(10, 0, 0, 1))
+
+	tests := []struct {
+		piece   int
+		msgType int
+		length  int
+	}{
+		{0, metadataData, metadataPieceSize},
+		{2, metadataData, 100},
+		{3, metadataReject, 0},
+		{-1, metadataReject, 0},
+		// piece*16 KiB overflows into a negative offset
+		{1 << 49, metadataReject, 0},
+		{math.MaxInt, metadataReject, 0},
+	}
+	for _, tt := range tests {
+		if err := p.metadata.serve(tp.peer, tt.piece); err != nil {
+			t.Fatalf("serve(%d): %v", tt.piece, err)
+		}
+		msg, data := tp.next(t)
+		if msg.MsgType != tt.msgType || msg.Piece != tt.piece || len(data) != tt.length {
+			t.Errorf("serve(%d) = msg_type %d, piece %d, %d bytes, want msg_type %d, %d bytes",
+				tt.piece, msg.MsgType, msg.Piece, len(data), tt.msgType, tt.length)
+		}
+	}
+}
+
+func TestMetadataSkipsSizeOfBadPeer(t *testing.T) {
+	p := NewProtocol(&algorithms.TorrentClient{})
+	m := p.metadata
+	liar := newTestPeer(t, p, net.IPv4(10, 0, 0, 1))
+	honest := newTestPeer(t, p, net.IPv4(10, 0, 0, 2))
+
+	// the download was started with the size the liar gave, then it rejected us
+	m.mu.Lock()
+	m.peers[liar.peer] = &metadataPeer{supported: true, size: 5 * metadataPieceSize, bad: true}
+	m.peers[honest.peer] = &metadataPeer{supported: true, size: metadataPieceSize + 1}
+	m.download.start(5 * metadataPieceSize)
+	m.mu.Unlock()
+
+	m.request()
+	for piece := 0; piece < 2; piece++ {
+		if msg, _ := honest.next(t); msg.MsgType != metadataRequest {
+			t.Fatalf("honest peer got msg_type %d, want a request", msg.MsgType)
+		}
+	}
+	m.mu.Lock()
+	size := m.download.size
+	m.mu.Unlock()
+	if size != metadataPieceSize+1 {
+		t.Errorf("download size = %d, want the honest peer's", size)
+	}
+}
+
+func TestMetadataAsksOnlyPeerAgainAfterTimeout(t *testing.T) {
+	p := NewProtocol(&algorithms.TorrentClient{})
+	m := p.metadata
+	tp := newTestPeer(t, p, net.IPv4(10, 0, 0, 1))
+
+	m.mu.Lock()
+	m.peers[tp.peer] = &metadataPeer{supported: true, size: 100}
+	m.download.start(100)
+	m.download.requests[0] = metadataPieceRequest{peer: tp.peer, sent: time.Now().Add(-2 * metadataRequestTimeout)}
+	m.mu.Unlock()
+
+	m.request()
+	if msg, _ := tp.next(t); msg.MsgType != metadataRequest || msg.Piece != 0 {
+		t.Errorf("got msg_type %d for piece %d, want piece 0 asked again", msg.MsgType, msg.Piece)
+	}
+	m.mu.Lock()
+	retrying := m.retrying
+	m.mu.Unlock()
+	if !retrying {
+		t.Error("no retry scheduled while the piece is missing")
+	}
+}
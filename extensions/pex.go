@@ -0,0 +1,325 @@
+package extensions
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+	"torrent-client/algorithms"
+	"torrent-client/utils"
+)
+
+const (
+	// BEP 11 asks for at most one message a minute per peer
+	pexInterval = time.Minute
+	// pexMaxPeers caps every added and dropped list in one message, what we
+	// send and what we take from a peer
+	pexMaxPeers = 50
+	// messages closer together than this are ignored, a peer flooding us
+	// with addresses is not going to get them all connected to
+	pexMinReceiveInterval = 30 * time.Second
+)
+
+// added.f flags
+const (
+	pexFlagSeed      = 0x02
+	pexFlagReachable = 0x10
+)
+
+// pexMessage is a ut_pex message, every list is compact peers, 6 bytes each
+// for IPv4 and 18 for IPv6, with one flag byte per added peer.
+type pexMessage struct {
+	Added    string `bencode:"added,omitempty"`
+	AddedF   string `bencode:"added.f,omitempty"`
+	Added6   string `bencode:"added6,omitempty"`
+	Added6F  string `bencode:"added6.f,omitempty"`
+	Dropped  string `bencode:"dropped,omitempty"`
+	Dropped6 string `bencode:"dropped6,omitempty"`
+}
+
+type pexEntry struct {
+	ip    net.IP
+	port  uint16
+	flags byte
+}
+
+func (e pexEntry) compact() []byte {
+	ip := e.ip.To4()
+	if ip == nil {
+		ip = e.ip.To16()
+	}
+	return binary.BigEndian.AppendUint16(append([]byte(nil), ip...), e.port)
+}
+
+// pexPeer is what we told one peer so far.
+type pexPeer struct {
+	sent         map[string]pexEntry
+	lastReceived time.Time
+	stop         chan struct{}
+}
+
+// pexExtension is ut_pex (BEP 11), it tells peers about the others we are
+// connected to and hands the ones they tell us about to the client. It is
+// switched off for private torrents and until the metadata is known.
+type pexExtension struct {
+	protocol *Protocol
+
+	mu    sync.Mutex
+	peers map[*algorithms.Peer]*pexPeer
+	// learned are the peers we got over ut_pex, dropped again if the torrent
+	// turns out to be private
+	learned map[string]*algorithms.Peer
+}
+
+func newPexExtension(protocol *Protocol) *pexExtension {
+	return &pexExtension{
+		protocol: protocol,
+		peers:    make(map[*algorithms.Peer]*pexPeer),
+		learned:  make(map[string]*algorithms.Peer),
+	}
+}
+
+// FillHandshake keeps ut_pex off in our handshake until peer exchange is
+// allowed.
+func (x *pexExtension) FillHandshake(hs *Handshake) {
+	if !x.protocol.PeerExchange() {
+		hs.M["ut_pex"] = 0
+	}
+}
+
+func (x *pexExtension) PeerHandshake(peer *algorithms.Peer, hs *Handshake) error {
+	x.update(peer)
+	return nil
+}
+
+// enable starts the exchange with every peer that supports it, once the
+// metadata shows the torrent is public.
+func (x *pexExtension) enable() {
+	for _, peer := range x.protocol.peerList() {
+		x.update(peer)
+	}
+}
+
+// disable stops every exchange and drops the peers it brought in, a
+// private torrent only gets its peers from the tracker.
+func (x *pexExtension) disable() {
+	x.mu.Lock()
+	for peer, state := range x.peers {
+		close(state.stop)
+		delete(x.peers, peer)
+	}
+	learned := x.learned
+	x.learned = make(map[string]*algorithms.Peer)
+	x.mu.Unlock()
+
+	for _, peer := range learned {
+		x.protocol.Client.DropPeer(peer)
+	}
+}
+
+// update starts or stops the exchange with the peer to match what it
+// supports and whether peer exchange is allowed.
+func (x *pexExtension) update(peer *algorithms.Peer) {
+	// a later handshake may leave ut_pex out, the merged ids still have it
+	enabled := x.protocol.PeerExchange() && x.protocol.Supports(peer, "ut_pex")
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	state, running := x.peers[peer]
+	if !enabled {
+		if running {
+			close(state.stop)
+			delete(x.peers, peer)
+		}
+		return
+	}
+	if !running {
+		state = &pexPeer{sent: make(map[string]pexEntry), stop: make(chan struct{})}
+		x.peers[peer] = state
+		go x.run(peer, state.stop)
+	}
+}
+
+func (x *pexExtension) PeerDisconnected(peer *algorithms.Peer) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if state, ok := x.peers[peer]; ok {
+		close(state.stop)
+		delete(x.peers, peer)
+	}
+}
+
+// run sends the first message right away and then one a minute.
+func (x *pexExtension) run(peer *algorithms.Peer, stop chan struct{}) {
+	ticker := time.NewTicker(pexInterval)
+	defer ticker.Stop()
+	for {
+		if err := x.send(peer); err != nil {
+			log.Printf("❌ Failed to send peer exchange to %s: %v", peer.IP, err)
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// send tells the peer which peers were added and dropped since the last
+// message, anything over the caps goes out in the next one.
+func (x *pexExtension) send(peer *algorithms.Peer) error {
+	if !x.protocol.PeerExchange() {
+		return nil
+	}
+	current := x.swarm(peer)
+
+	x.mu.Lock()
+	state, ok := x.peers[peer]
+	if !ok {
+		x.mu.Unlock()
+		return nil
+	}
+	var msg pexMessage
+	var added, added6, dropped, dropped6 int
+	for key, e := range current {
+		if _, ok := state.sent[key]; ok {
+			continue
+		}
+		if e.ip.To4() != nil && added < pexMaxPeers {
+			msg.Added += string(e.compact())
+			msg.AddedF += string([]byte{e.flags})
+			added++
+		} else if e.ip.To4() == nil && added6 < pexMaxPeers {
+			msg.Added6 += string(e.compact())
+			msg.Added6F += string([]byte{e.flags})
+			added6++
+		} else {
+			continue
+		}
+		state.sent[key] = e
+	}
+	for key, e := range state.sent {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		if e.ip.To4() != nil && dropped < pexMaxPeers {
+			msg.Dropped += string(e.compact())
+			dropped++
+		} else if e.ip.To4() == nil && dropped6 < pexMaxPeers {
+			msg.Dropped6 += string(e.compact())
+			dropped6++
+		} else {
+			continue
+		}
+		delete(state.sent, key)
+	}
+	x.mu.Unlock()
+
+	if added+added6+dropped+dropped6 == 0 {
+		return nil
+	}
+	payload, err := utils.MarshalBencode(msg)
+	if err != nil {
+		return err
+	}
+	return x.protocol.Send(peer, "ut_pex", payload)
+}
+
+// swarm is every connected peer but the one we are writing to. A peer that
+// connected to us is listed under the port from its extended handshake,
+// its own port is ephemeral.
+func (x *pexExtension) swarm(exclude *algorithms.Peer) map[string]pexEntry {
+	entries := make(map[string]pexEntry)
+	for _, peer := range x.protocol.Client.PeerList() {
+		if peer == exclude || !peer.Connected() || peer.IP.To16() == nil {
+			continue
+		}
+		e := pexEntry{ip: peer.IP, port: peer.PORT}
+		if peer.Inbound {
+			hs := x.protocol.PeerHandshake(peer)
+			if hs == nil || hs.P <= 0 || hs.P > 65535 {
+				continue
+			}
+			e.port = uint16(hs.P)
+		} else {
+			e.flags |= pexFlagReachable
+		}
+		if x.protocol.Client.PeerIsSeed(peer) {
+			e.flags |= pexFlagSeed
+		}
+		entries[fmt.Sprintf("%s:%d", e.ip, e.port)] = e
+	}
+	return entries
+}
+
+// HandleMessage merges the added peers into the client, dropped ones are
+// left alone since we may still be talking to them.
+func (x *pexExtension) HandleMessage(peer *algorithms.Peer, payload []byte) error {
+	if !x.protocol.PeerExchange() {
+		return nil
+	}
+	var msg pexMessage
+	if err := utils.UnmarshalBencode(payload, &msg); err != nil {
+		return fmt.Errorf("invalid ut_pex message: %w", err)
+	}
+
+	x.mu.Lock()
+	state, ok := x.peers[peer]
+	if !ok {
+		// we turned ut_pex off for this peer
+		x.mu.Unlock()
+		return nil
+	}
+	if time.Since(state.lastReceived) < pexMinReceiveInterval {
+		x.mu.Unlock()
+		log.Printf("🔎 Ignoring peer exchange from %s, too soon after the last one", peer.IP)
+		return nil
+	}
+	state.lastReceived = time.Now()
+	x.mu.Unlock()
+
+	peers, err := utils.ParsePeers([]byte(msg.Added[:min(len(msg.Added), pexMaxPeers*6)]))
+	if err != nil {
+		return fmt.Errorf("invalid ut_pex added list: %w", err)
+	}
+	peers6, err := utils.ParsePeers6([]byte(msg.Added6[:min(len(msg.Added6), pexMaxPeers*18)]))
+	if err != nil {
+		return fmt.Errorf("invalid ut_pex added6 list: %w", err)
+	}
+	for key, p := range peers6 {
+		peers[key] = p
+	}
+	for key, p := range peers {
+		if p.PORT == 0 || p.IP.IsUnspecified() {
+			delete(peers, key)
+		}
+	}
+
+	added := x.protocol.Client.AddPeers(peers)
+	x.remember(added)
+	if len(added) > 0 {
+		log.Printf("📡 Peer exchange from %s: %d new peers", peer.IP, len(added))
+		if x.protocol.OnNewPeers != nil {
+			x.protocol.OnNewPeers(added)
+		}
+	}
+	return nil
+}
+
+// remember notes the peers learned over ut_pex, the ones the client has
+// let go of since are forgotten.
+func (x *pexExtension) remember(added map[string]*algorithms.Peer) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for key, peer := range x.learned {
+		if current, ok := x.protocol.Client.Peer(key); !ok || current != peer {
+			delete(x.learned, key)
+		}
+	}
+	for key, peer := range added {
+		x.learned[key] = peer
+	}
+}
@@ -0,0 +1,363 @@
+package extensions
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"torrent-client/algorithms"
+	"torrent-client/metainfo"
+	"torrent-client/utils"
+)
+
+// extended message 0 is always the handshake, registered extensions get
+// ids from 1 in registration order
+const handshakeID = 0
+
+// DefaultVersion is the client name sent as v in the extended handshake.
+const DefaultVersion = "go-torrent 0.1"
+
+var ErrNotSupported = errors.New("extension not supported by peer")
+
+// Handshake is the bencoded payload of extended message 0. M maps extension
+// names to the message id the sender wants them on, 0 turns one off.
+type Handshake struct {
+	M map[string]int `bencode:"m"`
+	// V is the client name and version
+	V string `bencode:"v,omitempty"`
+	// P is the sender's listen port, useful when it connected to us
+	P int `bencode:"p,omitempty"`
+	// Reqq is how many outstanding requests the sender queues
+	Reqq int `bencode:"reqq,omitempty"`
+	// YourIP is the receiver's address as the sender sees it, 4 or 16 bytes
+	YourIP       string `bencode:"yourip,omitempty"`
+	MetadataSize int    `bencode:"metadata_size,omitempty"`
+}
+
+// Extension is one named extension carried over the extension protocol.
+type Extension interface {
+	// PeerHandshake is called for every extended handshake the peer sends.
+	// A later handshake only lists what changed, so whether the peer
+	// supports the extension comes from Protocol.Supports and not hs.M
+	PeerHandshake(peer *algorithms.Peer, hs *Handshake) error
+	// HandleMessage gets the payload of a message sent on our id for the
+	// extension, an error drops the peer
+	HandleMessage(peer *algorithms.Peer, payload []byte) error
+	PeerDisconnected(peer *algorithms.Peer)
+}
+
+// HandshakeFiller is implemented by extensions that put fields of their own
+// into our extended handshake, like metadata_size for ut_metadata.
+type HandshakeFiller interface {
+	FillHandshake(hs *Handshake)
+}
+
+// Protocol speaks the extension protocol (BEP 10) for one torrent and
+// implements algorithms.ExtensionHandler. Messages are routed to the
+// extensions registered by name, ut_metadata (BEP 9) is always there so a
+// torrent started from a magnet link can fetch its info dictionary, and so
+// is ut_pex (BEP 11). Peer exchange stays off until the metadata shows the
+// torrent isn't private.
+type Protocol struct {
+	Client *algorithms.TorrentClient
+	// Version is sent as v, DefaultVersion when empty
+	Version string
+	// ListenPort is sent as p so peers that connected to us can dial back
+	ListenPort int
+	// OnMetadata gets the info dictionary once it has been fetched and
+	// checked against the info hash, it has to set up the client for the
+	// pieces (storage and InitPieces) before the download can start
+	OnMetadata func(meta *metainfo.TorrentMeta) error
+	// OnNewPeers gets peers learned over ut_pex that were not in
+	// Client.Peers before, so the caller can connect to them
+	OnNewPeers func(peers map[string]*algorithms.Peer)
+
+	// private torrents (BEP 27) must not spread peers outside the tracker,
+	// and a magnet link doesn't tell, so pexAllowed is only set once the
+	// metadata says the torrent is public
+	private    atomic.Bool
+	pexAllowed atomic.Bool
+	mu         sync.Mutex
+	names      []string
+	extensions map[string]Extension
+	peers      map[*algorithms.Peer]*peerState
+	metadata   *metadataExtension
+	pex        *pexExtension
+}
+
+// peerState is what the peer told us in its latest extended handshake.
+type peerState struct {
+	handshake *Handshake
+	ids       map[string]int
+}
+
+// NewProtocol creates the extension protocol for the client and installs it
+// as client.Extensions, ut_metadata and ut_pex are registered.
+func NewProtocol(client *algorithms.TorrentClient) *Protocol {
+	p := &Protocol{
+		Client:     client,
+		extensions: make(map[string]Extension),
+		peers:      make(map[*algorithms.Peer]*peerState),
+	}
+	p.metadata = newMetadataExtension(p)
+	p.pex = newPexExtension(p)
+	p.Register("ut_metadata", p.metadata)
+	p.Register("ut_pex", p.pex)
+	client.Extensions = p
+	return p
+}
+
+// Register adds an extension under the name peers know it by. Peers that
+// are already connected get a new handshake listing it.
+func (p *Protocol) Register(name string, ext Extension) error {
+	p.mu.Lock()
+	if _, ok := p.extensions[name]; ok {
+		p.mu.Unlock()
+		return fmt.Errorf("extension %q is already registered", name)
+	}
+	if len(p.names) == 255 {
+		p.mu.Unlock()
+		return fmt.Errorf("no extension id left for %q", name)
+	}
+	p.names = append(p.names, name)
+	p.extensions[name] = ext
+	p.mu.Unlock()
+
+	p.resendHandshakes()
+	return nil
+}
+
+// SetMetadata gives the protocol the metadata of a torrent loaded from a
+// .torrent file so it can be served to peers.
+func (p *Protocol) SetMetadata(meta *metainfo.TorrentMeta) {
+	p.metadata.setInfo(meta.InfoBytes)
+	p.setPrivate(meta.Info.Private == 1)
+}
+
+// Private reports whether the torrent is private, peer exchange is off then.
+// It is false while the metadata is unknown, but so is PeerExchange.
+func (p *Protocol) Private() bool {
+	return p.private.Load()
+}
+
+// PeerExchange reports whether ut_pex may be used, only for a torrent whose
+// metadata is known and says it isn't private.
+func (p *Protocol) PeerExchange() bool {
+	return p.pexAllowed.Load()
+}
+
+// setPrivate is called once the metadata is known.
+func (p *Protocol) setPrivate(private bool) {
+	p.private.Store(private)
+	if private {
+		p.pex.disable()
+	}
+	if p.pexAllowed.Swap(!private) != !private {
+		// the peers have to learn that ut_pex is on or off now
+		p.resendHandshakes()
+		if !private {
+			p.pex.enable()
+		}
+	}
+}
+
+// Supports reports whether the peer's handshake listed the extension.
+func (p *Protocol) Supports(peer *algorithms.Peer, name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.remoteID(peer, name) != 0
+}
+
+// PeerHandshake is what the peer's extended handshakes told us so far, a
+// field a later one left out keeps its earlier value. It is nil if the peer
+// hasn't sent one yet.
+func (p *Protocol) PeerHandshake(peer *algorithms.Peer) *Handshake {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state, ok := p.peers[peer]; ok {
+		return state.handshake
+	}
+	return nil
+}
+
+// Send delivers an extension message on the id the peer assigned to name.
+func (p *Protocol) Send(peer *algorithms.Peer, name string, payload []byte) error {
+	p.mu.Lock()
+	id := p.remoteID(peer, name)
+	p.mu.Unlock()
+	if id == 0 {
+		return fmt.Errorf("%s: %w", name, ErrNotSupported)
+	}
+	return peer.SendExtended(byte(id), payload)
+}
+
+func (p *Protocol) PeerConnected(peer *algorithms.Peer) error {
+	p.mu.Lock()
+	p.peers[peer] = &peerState{ids: make(map[string]int)}
+	p.mu.Unlock()
+	return p.sendHandshake(peer)
+}
+
+func (p *Protocol) HandleExtended(peer *algorithms.Peer, id byte, payload []byte) error {
+	if id == handshakeID {
+		var hs Handshake
+		if err := utils.UnmarshalBencode(payload, &hs); err != nil {
+			return fmt.Errorf("invalid extended handshake: %w", err)
+		}
+		return p.handleHandshake(peer, &hs)
+	}
+
+	p.mu.Lock()
+	var ext Extension
+	if int(id) <= len(p.names) {
+		ext = p.extensions[p.names[id-1]]
+	}
+	p.mu.Unlock()
+	if ext == nil {
+		log.Printf("🔎 Unknown extended message ID: %d", id)
+		return nil
+	}
+	return ext.HandleMessage(peer, payload)
+}
+
+func (p *Protocol) PeerDisconnected(peer *algorithms.Peer) {
+	p.mu.Lock()
+	delete(p.peers, peer)
+	exts := p.registered()
+	p.mu.Unlock()
+
+	for _, ext := range exts {
+		ext.PeerDisconnected(peer)
+	}
+}
+
+// resendHandshakes tells every connected peer what changed, BEP 10 allows a
+// handshake at any time.
+func (p *Protocol) resendHandshakes() {
+	for _, peer := range p.peerList() {
+		if err := p.sendHandshake(peer); err != nil {
+			log.Printf("❌ Failed to send extended handshake to %s: %v", peer.IP, err)
+		}
+	}
+}
+
+// peerList is every peer we exchanged extended handshakes with.
+func (p *Protocol) peerList() []*algorithms.Peer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]*algorithms.Peer, 0, len(p.peers))
+	for peer := range p.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (p *Protocol) sendHandshake(peer *algorithms.Peer) error {
+	p.mu.Lock()
+	hs := Handshake{
+		M:    make(map[string]int, len(p.names)),
+		V:    p.Version,
+		P:    p.ListenPort,
+		Reqq: algorithms.MaxUploadQueue,
+	}
+	for i, name := range p.names {
+		hs.M[name] = i + 1
+	}
+	exts := p.registered()
+	p.mu.Unlock()
+
+	if hs.V == "" {
+		hs.V = DefaultVersion
+	}
+	if ip := peer.IP.To4(); ip != nil {
+		hs.YourIP = string(ip)
+	} else if ip := peer.IP.To16(); ip != nil {
+		hs.YourIP = string(ip)
+	}
+	for _, ext := range exts {
+		if filler, ok := ext.(HandshakeFiller); ok {
+			filler.FillHandshake(&hs)
+		}
+	}
+
+	payload, err := utils.MarshalBencode(hs)
+	if err != nil {
+		return err
+	}
+	return peer.SendExtended(handshakeID, payload)
+}
+
+// handleHandshake records the peer's ids and passes the handshake on to
+// every extension, a later handshake updates the earlier one.
+func (p *Protocol) handleHandshake(peer *algorithms.Peer, hs *Handshake) error {
+	p.mu.Lock()
+	state, ok := p.peers[peer]
+	if !ok {
+		p.mu.Unlock()
+		return nil
+	}
+	if state.handshake != nil {
+		hs.inherit(state.handshake)
+	}
+	state.handshake = hs
+	for name, id := range hs.M {
+		if id <= 0 || id > 255 {
+			// 0 disables the extension
+			delete(state.ids, name)
+			continue
+		}
+		state.ids[name] = id
+	}
+	exts := p.registered()
+	p.mu.Unlock()
+
+	if hs.Reqq > 0 {
+		peer.SetMaxRequests(hs.Reqq)
+	}
+	for _, ext := range exts {
+		if err := ext.PeerHandshake(peer, hs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inherit fills the fields a later handshake left out from the earlier one,
+// M is merged separately into peerState.ids.
+func (hs *Handshake) inherit(prev *Handshake) {
+	if hs.V == "" {
+		hs.V = prev.V
+	}
+	if hs.P == 0 {
+		hs.P = prev.P
+	}
+	if hs.Reqq == 0 {
+		hs.Reqq = prev.Reqq
+	}
+	if hs.YourIP == "" {
+		hs.YourIP = prev.YourIP
+	}
+	if hs.MetadataSize == 0 {
+		hs.MetadataSize = prev.MetadataSize
+	}
+}
+
+// remoteID is the id the peer wants the extension's messages on, 0 when
+// it doesn't support it. Must be called with p.mu held.
+func (p *Protocol) remoteID(peer *algorithms.Peer, name string) int {
+	state, ok := p.peers[peer]
+	if !ok {
+		return 0
+	}
+	return state.ids[name]
+}
+
+// registered lists the extensions in id order, must be called with p.mu held.
+func (p *Protocol) registered() []Extension {
+	exts := make([]Extension, 0, len(p.names))
+	for _, name := range p.names {
+		exts = append(exts, p.extensions[name])
+	}
+	return exts
+}
@@ -1,5 +1,3 @@
 module torrent-client
 
 go 1.24.0
-
-require github.com/jackpal/bencode-go v1.0.2 // indirect
@@ -0,0 +1,129 @@
+package metainfo
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Magnet is what a magnet:? link tells us, everything but the info hash is optional.
+type Magnet struct {
+	InfoHash    [20]byte
+	DisplayName string
+	Trackers    []string
+	// Peers are host:port addresses from x.pe
+	Peers []string
+	// WebSeeds are the BEP 19 urls from ws
+	WebSeeds []string
+	// SelectOnly lists the file indices from so, nil means every file
+	SelectOnly []int
+}
+
+func ParseMagnet(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %w", err)
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("not a magnet link: %s", uri)
+	}
+	params, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %w", err)
+	}
+
+	m := &Magnet{
+		DisplayName: params.Get("dn"),
+		Trackers:    params["tr"],
+		Peers:       params["x.pe"],
+		WebSeeds:    params["ws"],
+	}
+
+	found := false
+	for _, xt := range params["xt"] {
+		// a hybrid link also carries urn:btmh for v2, the v1 hash is what we use
+		if !strings.HasPrefix(xt, "urn:btih:") {
+			continue
+		}
+		m.InfoHash, err = parseInfoHash(strings.TrimPrefix(xt, "urn:btih:"))
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("magnet link has no urn:btih info hash")
+	}
+
+	if so := params.Get("so"); so != "" {
+		m.SelectOnly, err = parseSelectOnly(so)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// the info hash comes as 40 hex characters or, in older links, 32 base32 ones
+func parseInfoHash(s string) ([20]byte, error) {
+	var hash [20]byte
+	var raw []byte
+	var err error
+	switch len(s) {
+	case 40:
+		raw, err = hex.DecodeString(s)
+	case 32:
+		raw, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return hash, fmt.Errorf("info hash %q has invalid length %d", s, len(s))
+	}
+	if err != nil {
+		return hash, fmt.Errorf("invalid info hash %q: %w", s, err)
+	}
+	copy(hash[:], raw)
+	return hash, nil
+}
+
+// maxSelectOnly caps the file indices so may expand to, the link is
+// untrusted and a range like 0-2147483647 must not allocate them all
+const maxSelectOnly = 100000
+
+// parseSelectOnly expands so=0,2,4-6 into the file indices
+func parseSelectOnly(s string) ([]int, error) {
+	var indices []int
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid so entry %q", part)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(hi)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid so range %q", part)
+			}
+		}
+		if end-start >= maxSelectOnly-len(indices) {
+			return nil, fmt.Errorf("so selects more than %d files", maxSelectOnly)
+		}
+		// counted from start, i <= end never fails when end is the largest int
+		for n := 0; n <= end-start; n++ {
+			indices = append(indices, start+n)
+		}
+	}
+	return indices, nil
+}
+
+// AnnounceTiers puts all the magnet trackers in one tier, the link has no
+// notion of tiers.
+func (m *Magnet) AnnounceTiers() [][]string {
+	if len(m.Trackers) == 0 {
+		return nil
+	}
+	return [][]string{m.Trackers}
+}
@@ -0,0 +1,240 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"torrent-client/utils"
+)
+
+// File is one entry of the info.files list of a multi-file torrent.
+// Offset is not part of the bencoded data, it is the position of the file
+// inside the concatenated piece stream and is filled in after decoding.
+type File struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+	MD5Sum string   `bencode:"md5sum"`
+	Offset int64    `bencode:"-"`
+}
+
+// DisplayPath joins the path components with the OS separator.
+func (f File) DisplayPath() string {
+	return filepath.Join(f.Path...)
+}
+
+// why info dict is necessary because it have to get the exact info of the torrent file and parse that out
+type InfoDict struct {
+	PieceLength int64  `bencode:"piece length"`
+	Pieces      string `bencode:"pieces"`
+	Name        string `bencode:"name"`
+	Length      int64  `bencode:"length"`
+	MD5Sum      string `bencode:"md5sum"`
+	Files       []File `bencode:"files"`
+	Private     int    `bencode:"private"`
+	MetaVersion int    `bencode:"meta version"`
+}
+
+// InfoBytes holds the info value exactly as it appeared in the file and the
+// hashes are computed over it, re-encoding InfoDict would drop every key the
+// struct does not model and give a hash no tracker knows about.
+type TorrentMeta struct {
+	Announce     string     `bencode:"announce"`
+	AnnounceList [][]string `bencode:"announce-list"`
+	Info         InfoDict   `bencode:"info"`
+	InfoBytes    []byte     `bencode:"-"`
+	InfoHash     [20]byte   `bencode:"-"`
+	InfoHashV2   [32]byte   `bencode:"-"`
+}
+
+func Load(filePath string) (*TorrentMeta, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+func Parse(r io.Reader) (*TorrentMeta, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta TorrentMeta
+	if err := utils.UnmarshalBencode(data, &meta); err != nil {
+		return nil, err
+	}
+	if err := meta.Info.validate(); err != nil {
+		return nil, err
+	}
+	meta.Info.computeOffsets()
+
+	meta.InfoBytes, err = utils.RawDictValue(data, "info")
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate info dict: %w", err)
+	}
+	meta.InfoHash = sha1.Sum(meta.InfoBytes)
+	meta.InfoHashV2 = sha256.Sum256(meta.InfoBytes)
+	return &meta, nil
+}
+
+// ParseInfo builds a TorrentMeta around a bare info dictionary, which is all
+// a magnet link gets from its peers, so there are no trackers in it.
+func ParseInfo(info []byte) (*TorrentMeta, error) {
+	var meta TorrentMeta
+	if err := utils.UnmarshalBencode(info, &meta.Info); err != nil {
+		return nil, err
+	}
+	if err := meta.Info.validate(); err != nil {
+		return nil, err
+	}
+	meta.Info.computeOffsets()
+
+	meta.InfoBytes = append([]byte(nil), info...)
+	meta.InfoHash = sha1.Sum(meta.InfoBytes)
+	meta.InfoHashV2 = sha256.Sum256(meta.InfoBytes)
+	return &meta, nil
+}
+
+// IsV2 reports whether the torrent carries v2 metadata, only then
+// InfoHashV2 is what v2 peers and trackers use.
+func (meta *TorrentMeta) IsV2() bool {
+	return meta.Info.MetaVersion == 2
+}
+
+func (info *InfoDict) validate() error {
+	if info.Name == "" {
+		return fmt.Errorf("info dict has no name")
+	}
+	if info.PieceLength <= 0 {
+		return fmt.Errorf("invalid piece length: %d", info.PieceLength)
+	}
+	if len(info.Files) == 0 {
+		if info.Length < 0 {
+			return fmt.Errorf("invalid length: %d", info.Length)
+		}
+		return nil
+	}
+	if info.Length != 0 {
+		return fmt.Errorf("info dict has both length and files")
+	}
+	for i, f := range info.Files {
+		if f.Length < 0 {
+			return fmt.Errorf("file %d has invalid length: %d", i, f.Length)
+		}
+		if len(f.Path) == 0 {
+			return fmt.Errorf("file %d has an empty path", i)
+		}
+	}
+	return nil
+}
+
+// so every file starts right where the previous one ended in the piece stream
+func (info *InfoDict) computeOffsets() {
+	var offset int64
+	for i := range info.Files {
+		info.Files[i].Offset = offset
+		offset += info.Files[i].Length
+	}
+}
+
+func (info *InfoDict) IsMultiFile() bool {
+	return len(info.Files) > 0
+}
+
+// TotalLength is the size of the whole torrent, for multi-file torrents
+// it is the sum of all the file lengths.
+func (info *InfoDict) TotalLength() int64 {
+	if !info.IsMultiFile() {
+		return info.Length
+	}
+	var total int64
+	for _, f := range info.Files {
+		total += f.Length
+	}
+	return total
+}
+
+// FileList returns the files with their offsets, a single-file torrent is
+// reported as one file named after info.name.
+func (info *InfoDict) FileList() []File {
+	if !info.IsMultiFile() {
+		return []File{{Length: info.Length, Path: []string{info.Name}, MD5Sum: info.MD5Sum}}
+	}
+	files := make([]File, len(info.Files))
+	copy(files, info.Files)
+	return files
+}
+
+// FilePieces marks the pieces holding any byte of the given files (indices
+// into FileList), they are what has to be downloaded to get those files.
+// Indices past the last file are ignored as BEP 53 allows, the link was
+// written without knowing the file list.
+func (meta *TorrentMeta) FilePieces(files []int) []bool {
+	list := meta.Info.FileList()
+	pieces := make([]bool, meta.NumPieces())
+	for _, i := range files {
+		if i < 0 || i >= len(list) {
+			continue
+		}
+		f := list[i]
+		if f.Length == 0 {
+			continue
+		}
+		first := f.Offset / meta.Info.PieceLength
+		last := (f.Offset + f.Length - 1) / meta.Info.PieceLength
+		for p := first; p <= last; p++ {
+			pieces[p] = true
+		}
+	}
+	return pieces
+}
+
+// AnnounceTiers returns the announce-list tiers, torrents without one get a
+// single tier holding the announce url.
+func (meta *TorrentMeta) AnnounceTiers() [][]string {
+	var tiers [][]string
+	for _, tier := range meta.AnnounceList {
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	if len(tiers) == 0 && meta.Announce != "" {
+		tiers = append(tiers, []string{meta.Announce})
+	}
+	return tiers
+}
+
+// Pieces splits info.pieces into the 20 byte SHA-1 hashes and checks that
+// there is exactly one hash for every piece of the torrent.
+func (meta *TorrentMeta) Pieces() ([][20]byte, error) {
+	const hashLen = 20
+	data := []byte(meta.Info.Pieces)
+	if len(data)%hashLen != 0 {
+		return nil, fmt.Errorf("pieces length %d is not a multiple of %d", len(data), hashLen)
+	}
+
+	numHashes := len(data) / hashLen
+	if expected := meta.NumPieces(); int64(numHashes) != expected {
+		return nil, fmt.Errorf("got %d piece hashes, expected %d", numHashes, expected)
+	}
+
+	hashes := make([][20]byte, numHashes)
+	for i := range hashes {
+		copy(hashes[i][:], data[i*hashLen:])
+	}
+	return hashes, nil
+}
+
+// NumPieces is ceil(total length / piece length).
+func (meta *TorrentMeta) NumPieces() int64 {
+	if meta.Info.PieceLength <= 0 {
+		return 0
+	}
+	return (meta.Info.TotalLength() + meta.Info.PieceLength - 1) / meta.Info.PieceLength
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+	"torrent-client/metainfo"
+	"torrent-client/tracker"
+)
+
+const scrapeTimeout = 30 * time.Second
+
+// runScrape prints the swarm health of every torrent on every tracker it
+// lists, torrents sharing a tracker are scraped in one batch.
+func runScrape(paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("usage: torrent-client scrape <file.torrent>...")
+	}
+
+	names := make(map[[20]byte]string)
+	byTracker := make(map[string][][20]byte)
+	var trackerOrder []string
+
+	for _, path := range paths {
+		meta, err := metainfo.Load(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		names[meta.InfoHash] = meta.Info.Name
+		for _, tier := range meta.AnnounceTiers() {
+			for _, u := range tier {
+				if _, ok := byTracker[u]; !ok {
+					trackerOrder = append(trackerOrder, u)
+				}
+				byTracker[u] = append(byTracker[u], meta.InfoHash)
+			}
+		}
+	}
+
+	for _, u := range trackerOrder {
+		fmt.Printf("📡 %s\n", u)
+		t, err := tracker.New(u)
+		if err != nil {
+			fmt.Printf("   ❌ %v\n", err)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+		results, err := t.Scrape(ctx, byTracker[u])
+		cancel()
+		if err != nil {
+			fmt.Printf("   ❌ %v\n", err)
+			continue
+		}
+		for _, r := range results {
+			fmt.Printf("   %s %s seeders=%d leechers=%d completed=%d\n",
+				hex.EncodeToString(r.InfoHash[:]), names[r.InfoHash], r.Seeders, r.Leechers, r.Completed)
+		}
+	}
+	return nil
+}
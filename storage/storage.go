@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxOpenFiles bounds the file handles a FileStorage keeps open.
+const DefaultMaxOpenFiles = 32
+
+// Storage reads and writes piece data, offset is relative to the start of
+// the piece.
+type Storage interface {
+	ReadAt(piece int, p []byte, offset int64) (int, error)
+	WriteAt(piece int, p []byte, offset int64) (int, error)
+	Close() error
+}
+
+// File is one file of the torrent, Path holds the components below the
+// storage directory, for a multi-file torrent the first one is info.name.
+type File struct {
+	Path   []string
+	Length int64
+}
+
+type fileEntry struct {
+	path   string
+	offset int64
+	length int64
+}
+
+// FileStorage maps the piece stream onto the files of the torrent. Files are
+// opened on first use and the least recently used handle is closed once
+// MaxOpenFiles are open.
+type FileStorage struct {
+	MaxOpenFiles int
+
+	dir         string
+	files       []fileEntry
+	pieceLength int64
+	totalLength int64
+
+	mu    sync.Mutex
+	open  map[int]*list.Element
+	lru   *list.List // of *openFile, most recently used at the front
+	mkdir map[string]bool
+}
+
+type openFile struct {
+	index int
+	f     *os.File
+}
+
+func NewFileStorage(dir string, files []File, pieceLength int64) (*FileStorage, error) {
+	if pieceLength <= 0 {
+		return nil, fmt.Errorf("invalid piece length: %d", pieceLength)
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStorage{
+		MaxOpenFiles: DefaultMaxOpenFiles,
+		dir:          root,
+		pieceLength:  pieceLength,
+		open:         make(map[int]*list.Element),
+		lru:          list.New(),
+		mkdir:        make(map[string]bool),
+	}
+	for _, f := range files {
+		path, err := safeJoin(root, f.Path)
+		if err != nil {
+			return nil, err
+		}
+		if f.Length < 0 {
+			return nil, fmt.Errorf("file %s has negative length", path)
+		}
+		s.files = append(s.files, fileEntry{path: path, offset: s.totalLength, length: f.Length})
+		s.totalLength += f.Length
+	}
+
+	// empty files never get a write so they are created right away
+	for i, f := range s.files {
+		if f.length == 0 {
+			if _, err := s.file(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// safeJoin builds the on-disk path from torrent supplied components, a
+// malicious torrent must not be able to write outside dir.
+func safeJoin(dir string, components []string) (string, error) {
+	if len(components) == 0 {
+		return "", fmt.Errorf("empty file path")
+	}
+	for _, c := range components {
+		if c == "" || c == "." || c == ".." || strings.ContainsAny(c, "/\\\x00") || filepath.IsAbs(c) || filepath.VolumeName(c) != "" {
+			return "", fmt.Errorf("unsafe path component %q", c)
+		}
+	}
+	path := filepath.Join(append([]string{dir}, components...)...)
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == ".." {
+		return "", fmt.Errorf("path %q escapes the download directory", filepath.Join(components...))
+	}
+	return path, nil
+}
+
+func (s *FileStorage) ReadAt(piece int, p []byte, offset int64) (int, error) {
+	return s.span(piece, p, offset, func(f *os.File, b []byte, off int64) (int, error) {
+		n, err := f.ReadAt(b, off)
+		if errors.Is(err, io.EOF) {
+			// the file is shorter than it should be, the data was never written
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	})
+}
+
+func (s *FileStorage) WriteAt(piece int, p []byte, offset int64) (int, error) {
+	return s.span(piece, p, offset, func(f *os.File, b []byte, off int64) (int, error) {
+		return f.WriteAt(b, off)
+	})
+}
+
+// span walks the files covered by the request, a piece can start in one
+// file and end several files later.
+func (s *FileStorage) span(piece int, p []byte, offset int64, op func(*os.File, []byte, int64) (int, error)) (int, error) {
+	if piece < 0 || offset < 0 || offset+int64(len(p)) > s.pieceLength {
+		return 0, fmt.Errorf("piece %d offset %d length %d out of range", piece, offset, len(p))
+	}
+	start := int64(piece)*s.pieceLength + offset
+	if start+int64(len(p)) > s.totalLength {
+		return 0, fmt.Errorf("piece %d offset %d length %d past end of torrent", piece, offset, len(p))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	done := 0
+	for i, fe := range s.files {
+		if done == len(p) {
+			break
+		}
+		pos := start + int64(done)
+		if pos >= fe.offset+fe.length || fe.length == 0 {
+			continue
+		}
+		chunk := p[done:min(int64(len(p)), int64(done)+fe.offset+fe.length-pos)]
+		f, err := s.file(i)
+		if err != nil {
+			return done, err
+		}
+		n, err := op(f, chunk, pos-fe.offset)
+		done += n
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// file must be called with s.mu held
+func (s *FileStorage) file(index int) (*os.File, error) {
+	if el, ok := s.open[index]; ok {
+		s.lru.MoveToFront(el)
+		return el.Value.(*openFile).f, nil
+	}
+
+	path := s.files[index].path
+	if dir := filepath.Dir(path); !s.mkdir[dir] {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		s.mkdir[dir] = true
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	maxOpen := s.MaxOpenFiles
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpenFiles
+	}
+	for s.lru.Len() >= maxOpen {
+		oldest := s.lru.Back()
+		of := oldest.Value.(*openFile)
+		of.f.Close()
+		delete(s.open, of.index)
+		s.lru.Remove(oldest)
+	}
+	s.open[index] = s.lru.PushFront(&openFile{index: index, f: f})
+	return f, nil
+}
+
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for el := s.lru.Front(); el != nil; el = el.Next() {
+		if err := el.Value.(*openFile).f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.lru.Init()
+	s.open = make(map[int]*list.Element)
+	return errors.Join(errs...)
+}
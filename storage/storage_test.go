@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		components []string
+		want       string
+	}{
+		{[]string{"a.txt"}, filepath.Join(dir, "a.txt")},
+		{[]string{"dir", "sub", "a.txt"}, filepath.Join(dir, "dir", "sub", "a.txt")},
+		{[]string{"..a"}, filepath.Join(dir, "..a")},
+		{[]string{"a..", "b"}, filepath.Join(dir, "a..", "b")},
+	}
+	for _, tt := range tests {
+		got, err := safeJoin(dir, tt.components)
+		if err != nil {
+			t.Errorf("safeJoin(%q): %v", tt.components, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("safeJoin(%q) = %q, want %q", tt.components, got, tt.want)
+		}
+	}
+}
+
+func TestSafeJoinRejectsUnsafePaths(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		components []string
+	}{
+		{"empty path", nil},
+		{"empty component", []string{"a", "", "b"}},
+		{"dot", []string{"."}},
+		{"dot dot", []string{".."}},
+		{"dot dot in the middle", []string{"a", "..", "..", "b"}},
+		{"slash", []string{"a/../../b"}},
+		{"backslash", []string{`..\..\b`}},
+		{"nul", []string{"a\x00b"}},
+		{"absolute", []string{"/etc/passwd"}},
+		{"volume", []string{`C:\Windows`}},
+		{"unc", []string{`\\server\share`}},
+	}
+	for _, tt := range tests {
+		if got, err := safeJoin(dir, tt.components); err == nil {
+			t.Errorf("%s: safeJoin(%q) = %q, want an error", tt.name, tt.components, got)
+		}
+	}
+}
+
+func TestNewFileStorageRejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "download")
+	files := []File{
+		{Path: []string{"ok.txt"}, Length: 1},
+		{Path: []string{"..", "escaped.txt"}, Length: 0},
+	}
+	if _, err := NewFileStorage(dir, files, 16); err == nil {
+		t.Fatal("NewFileStorage accepted a path outside the directory")
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("escaped.txt was created outside the directory: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package tracker
+
+import (
+	"context"
+	"log"
+	"time"
+	"torrent-client/algorithms"
+)
+
+// how long the final stopped announce may take once the client shuts down
+const stoppedTimeout = 5 * time.Second
+
+// unknownLeft is reported while a magnet torrent has no metadata, trackers
+// only care that it isn't zero so we are not counted as a seed
+const unknownLeft = 16 * 1024
+
+// Announcer keeps one torrent announced for as long as Run is going, it
+// reads the transfer counters from the client so every announce reports
+// what actually happened.
+type Announcer struct {
+	Trackers *TierList
+	Client   *algorithms.TorrentClient
+	InfoHash [20]byte
+	PeerID   string
+	Port     int
+	NumWant  int
+	// OnNewPeers gets the peers that were not in Client.Peers before, so the
+	// caller can connect to them
+	OnNewPeers func(peers map[string]*algorithms.Peer)
+}
+
+// Run announces started, re-announces on the tracker interval, sends
+// completed when the last piece is verified and stopped when ctx ends.
+func (a *Announcer) Run(ctx context.Context) {
+	wait, ok := a.announce(ctx, EventStarted)
+	// started and completed are retried on the next announce if the tracker
+	// missed them, started goes first
+	startedPending := !ok
+	done := a.Client.Done()
+	if a.Client.HasMetadata() && a.Client.BytesLeft() == 0 {
+		// started as a seed, there is nothing to complete
+		done = nil
+	}
+	completedPending := false
+
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			stopCtx, cancel := context.WithTimeout(context.Background(), stoppedTimeout)
+			a.announce(stopCtx, EventStopped)
+			cancel()
+			return
+		case <-done:
+			timer.Stop()
+			// a nil channel blocks forever so this case only fires once
+			done = nil
+			completedPending = true
+		case <-timer.C:
+		}
+
+		event := EventNone
+		switch {
+		case startedPending:
+			event = EventStarted
+		case completedPending:
+			event = EventCompleted
+		}
+		wait, ok = a.announce(ctx, event)
+		if !ok {
+			continue
+		}
+		switch event {
+		case EventStarted:
+			startedPending = false
+		case EventCompleted:
+			completedPending = false
+		}
+	}
+}
+
+// announce returns how long to wait before the next regular announce.
+func (a *Announcer) announce(ctx context.Context, event Event) (time.Duration, bool) {
+	req := &AnnounceRequest{
+		InfoHash:   a.InfoHash,
+		PeerID:     a.PeerID,
+		Port:       a.Port,
+		Uploaded:   a.Client.BytesUploaded(),
+		Downloaded: a.Client.BytesDownloaded(),
+		Left:       a.Client.BytesLeft(),
+		Event:      event,
+		NumWant:    a.NumWant,
+	}
+	if !a.Client.HasMetadata() {
+		req.Left = unknownLeft
+	}
+	if event == EventStopped {
+		req.NumWant = 0
+	}
+
+	resp, url, err := a.Trackers.Announce(ctx, req)
+	if err != nil {
+		log.Printf("❌ Announce failed: %v", err)
+		return a.retryDelay(), false
+	}
+	if resp.WarningMessage != "" {
+		log.Printf("⚠️ Tracker %s warning: %s", url, resp.WarningMessage)
+	}
+
+	added := a.Client.AddPeers(resp.Peers)
+	log.Printf("📡 Announced to %s (%d new peers of %d)", url, len(added), len(resp.Peers))
+	if len(added) > 0 && a.OnNewPeers != nil && event != EventStopped {
+		a.OnNewPeers(added)
+	}
+	return NextInterval(resp), true
+}
+
+// retryDelay waits for the earliest tracker that is due again.
+func (a *Announcer) retryDelay() time.Duration {
+	var next time.Time
+	for _, s := range a.Trackers.Status() {
+		if next.IsZero() || s.NextAnnounce.Before(next) {
+			next = s.NextAnnounce
+		}
+	}
+	if d := time.Until(next); d > minRetryDelay {
+		return d
+	}
+	return minRetryDelay
+}
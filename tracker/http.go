@@ -0,0 +1,230 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"torrent-client/algorithms"
+	"torrent-client/utils"
+)
+
+// tracker responses are small, anything bigger than this is not a tracker talking
+const maxResponseSize = 2 << 20
+
+type HTTPTracker struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPTracker(announceURL string) *HTTPTracker {
+	return &HTTPTracker{
+		URL:    announceURL,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type httpAnnounceResponse struct {
+	FailureReason  string      `bencode:"failure reason"`
+	WarningMessage string      `bencode:"warning message"`
+	Interval       int64       `bencode:"interval"`
+	MinInterval    int64       `bencode:"min interval"`
+	TrackerID      string      `bencode:"tracker id"`
+	Complete       int64       `bencode:"complete"`
+	Incomplete     int64       `bencode:"incomplete"`
+	Peers          interface{} `bencode:"peers"`
+}
+
+func (t *HTTPTracker) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error) {
+	fullURL, err := t.announceURL(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := t.get(ctx, fullURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw httpAnnounceResponse
+	if err := utils.UnmarshalBencode(body, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse tracker response: %w", err)
+	}
+	if raw.FailureReason != "" {
+		return nil, &FailureError{Reason: raw.FailureReason}
+	}
+
+	resp := &AnnounceResponse{
+		Interval:       time.Duration(raw.Interval) * time.Second,
+		MinInterval:    time.Duration(raw.MinInterval) * time.Second,
+		TrackerID:      raw.TrackerID,
+		Complete:       int(raw.Complete),
+		Incomplete:     int(raw.Incomplete),
+		WarningMessage: raw.WarningMessage,
+	}
+
+	switch peers := raw.Peers.(type) {
+	case nil:
+		resp.Peers = map[string]*algorithms.Peer{}
+	case string: // compact format
+		resp.Peers, err = utils.ParsePeers([]byte(peers))
+	case []interface{}: // dictionary format
+		resp.Peers, err = utils.ParsePeersFromDict(peers)
+	default:
+		err = fmt.Errorf("unsupported peers format: %T", raw.Peers)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peers: %w", err)
+	}
+	return resp, nil
+}
+
+func (t *HTTPTracker) announceURL(req *AnnounceRequest) (string, error) {
+	base, err := url.Parse(t.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid announce url: %w", err)
+	}
+
+	params := url.Values{}
+	params.Add("peer_id", req.PeerID)
+	params.Add("port", strconv.Itoa(req.Port))
+	params.Add("uploaded", strconv.FormatInt(req.Uploaded, 10))
+	params.Add("downloaded", strconv.FormatInt(req.Downloaded, 10))
+	params.Add("left", strconv.FormatInt(req.Left, 10))
+	params.Add("compact", "1")
+	if req.Event != EventNone {
+		params.Add("event", string(req.Event))
+	}
+	if req.NumWant > 0 {
+		params.Add("numwant", strconv.Itoa(req.NumWant))
+	}
+	if req.TrackerID != "" {
+		params.Add("trackerid", req.TrackerID)
+	}
+
+	// the info hash is added by hand, url.Values would double encode it
+	query := "info_hash=" + utils.EncodeInfoHash(req.InfoHash) + "&" + params.Encode()
+	if base.RawQuery != "" {
+		query = base.RawQuery + "&" + query
+	}
+	base.RawQuery = query
+	return base.String(), nil
+}
+
+func (t *HTTPTracker) get(ctx context.Context, fullURL string) ([]byte, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.Client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("tracker request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tracker response: %w", err)
+	}
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("tracker response larger than %d bytes", maxResponseSize)
+	}
+	// some trackers send the failure reason with an error status, let the caller decode it
+	if resp.StatusCode != http.StatusOK && !strings.HasPrefix(string(body), "d") {
+		return nil, fmt.Errorf("tracker returned status %s", resp.Status)
+	}
+	return body, nil
+}
+
+// keeps the scrape url at a sane length, every hash is 60 characters escaped
+const maxHTTPScrapeHashes = 50
+
+type httpScrapeResponse struct {
+	FailureReason string `bencode:"failure reason"`
+	Files         map[string]struct {
+		Complete   int64 `bencode:"complete"`
+		Downloaded int64 `bencode:"downloaded"`
+		Incomplete int64 `bencode:"incomplete"`
+	} `bencode:"files"`
+}
+
+// ScrapeURL derives the scrape url the usual way, the last path component
+// has to start with "announce" which is replaced by "scrape".
+func ScrapeURL(announceURL string) (string, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid announce url: %w", err)
+	}
+	slash := strings.LastIndex(u.Path, "/")
+	last := u.Path[slash+1:]
+	if !strings.HasPrefix(last, "announce") {
+		return "", ErrScrapeUnsupported
+	}
+	u.Path = u.Path[:slash+1] + "scrape" + strings.TrimPrefix(last, "announce")
+	return u.String(), nil
+}
+
+func (t *HTTPTracker) Scrape(ctx context.Context, infoHashes [][20]byte) ([]ScrapeResult, error) {
+	scrapeURL, err := ScrapeURL(t.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ScrapeResult
+	for start := 0; start < len(infoHashes); start += maxHTTPScrapeHashes {
+		end := min(start+maxHTTPScrapeHashes, len(infoHashes))
+		batch, err := t.scrapeBatch(ctx, scrapeURL, infoHashes[start:end])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batch...)
+	}
+	return results, nil
+}
+
+func (t *HTTPTracker) scrapeBatch(ctx context.Context, scrapeURL string, infoHashes [][20]byte) ([]ScrapeResult, error) {
+	base, err := url.Parse(scrapeURL)
+	if err != nil {
+		return nil, err
+	}
+	query := base.RawQuery
+	for _, h := range infoHashes {
+		if query != "" {
+			query += "&"
+		}
+		query += "info_hash=" + utils.EncodeInfoHash(h)
+	}
+	base.RawQuery = query
+
+	body, err := t.get(ctx, base.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var raw httpScrapeResponse
+	if err := utils.UnmarshalBencode(body, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse scrape response: %w", err)
+	}
+	if raw.FailureReason != "" {
+		return nil, &FailureError{Reason: raw.FailureReason}
+	}
+
+	var results []ScrapeResult
+	for _, h := range infoHashes {
+		stats, ok := raw.Files[string(h[:])]
+		if !ok {
+			continue
+		}
+		results = append(results, ScrapeResult{
+			InfoHash:  h,
+			Seeders:   int(stats.Complete),
+			Completed: int(stats.Downloaded),
+			Leechers:  int(stats.Incomplete),
+		})
+	}
+	return results, nil
+}
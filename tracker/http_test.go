@@ -0,0 +1,161 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"torrent-client/utils"
+)
+
+// fakeHTTPTracker answers every announce with the bencoded response and
+// keeps the query strings it was sent.
+func fakeHTTPTracker(t *testing.T, response func(r *http.Request) map[string]interface{}) (*httptest.Server, *[]*http.Request) {
+	t.Helper()
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		body, err := utils.MarshalBencode(response(r))
+		if err != nil {
+			t.Errorf("encoding response: %v", err)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func testAnnounceRequest() *AnnounceRequest {
+	return &AnnounceRequest{
+		InfoHash: [20]byte{1, 2, 3},
+		PeerID:   "-GT0001-abcdefghijkl",
+		Port:     6881,
+		Left:     1000,
+		Event:    EventStarted,
+		NumWant:  30,
+	}
+}
+
+func TestHTTPAnnounceCompactPeers(t *testing.T) {
+	srv, requests := fakeHTTPTracker(t, func(r *http.Request) map[string]interface{} {
+		return map[string]interface{}{
+			"interval":     1800,
+			"min interval": 900,
+			"complete":     5,
+			"incomplete":   7,
+			"peers":        string([]byte{10, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0x1a, 0xe2}),
+		}
+	})
+
+	resp, err := NewHTTPTracker(srv.URL+"/announce").Announce(context.Background(), testAnnounceRequest())
+	if err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+	if resp.Interval != 30*time.Minute || resp.MinInterval != 15*time.Minute {
+		t.Errorf("intervals = %v, %v, want 30m, 15m", resp.Interval, resp.MinInterval)
+	}
+	if resp.Complete != 5 || resp.Incomplete != 7 {
+		t.Errorf("complete/incomplete = %d/%d, want 5/7", resp.Complete, resp.Incomplete)
+	}
+	for _, key := range []string{"10.0.0.1:6881", "10.0.0.2:6882"} {
+		if _, ok := resp.Peers[key]; !ok {
+			t.Errorf("peer %s missing from %v", key, resp.Peers)
+		}
+	}
+
+	q := (*requests)[0].URL.Query()
+	for param, want := range map[string]string{
+		"info_hash": string([]byte{1, 2, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}),
+		"port":      "6881",
+		"left":      "1000",
+		"event":     "started",
+		"numwant":   "30",
+		"compact":   "1",
+	} {
+		if got := q.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+}
+
+func TestHTTPAnnounceDictionaryPeers(t *testing.T) {
+	srv, _ := fakeHTTPTracker(t, func(r *http.Request) map[string]interface{} {
+		return map[string]interface{}{
+			"interval": 60,
+			"peers": []interface{}{
+				map[string]interface{}{"peer id": "-XX0001-000000000000", "ip": "192.168.1.5", "port": 51413},
+				map[string]interface{}{"ip": "2001:db8::1", "port": 6881},
+			},
+		}
+	})
+
+	resp, err := NewHTTPTracker(srv.URL+"/announce").Announce(context.Background(), testAnnounceRequest())
+	if err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+	if len(resp.Peers) != 2 {
+		t.Fatalf("got %d peers, want 2: %v", len(resp.Peers), resp.Peers)
+	}
+	if p, ok := resp.Peers["192.168.1.5:51413"]; !ok || p.PORT != 51413 {
+		t.Errorf("peer 192.168.1.5:51413 missing or wrong: %v", resp.Peers)
+	}
+	if _, ok := resp.Peers["2001:db8::1:6881"]; !ok {
+		t.Errorf("IPv6 peer missing: %v", resp.Peers)
+	}
+}
+
+func TestHTTPAnnounceFailureReason(t *testing.T) {
+	srv, _ := fakeHTTPTracker(t, func(r *http.Request) map[string]interface{} {
+		return map[string]interface{}{"failure reason": "torrent not registered"}
+	})
+
+	_, err := NewHTTPTracker(srv.URL+"/announce").Announce(context.Background(), testAnnounceRequest())
+	var failure *FailureError
+	if !errors.As(err, &failure) {
+		t.Fatalf("err = %v, want a FailureError", err)
+	}
+	if failure.Reason != "torrent not registered" {
+		t.Errorf("reason = %q", failure.Reason)
+	}
+}
+
+func TestHTTPAnnounceWarningMessage(t *testing.T) {
+	srv, _ := fakeHTTPTracker(t, func(r *http.Request) map[string]interface{} {
+		return map[string]interface{}{
+			"warning message": "tracker is moving",
+			"interval":        60,
+			"peers":           "",
+		}
+	})
+
+	resp, err := NewHTTPTracker(srv.URL+"/announce").Announce(context.Background(), testAnnounceRequest())
+	if err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+	if resp.WarningMessage != "tracker is moving" {
+		t.Errorf("warning = %q", resp.WarningMessage)
+	}
+}
+
+func TestHTTPTrackerIDIsEchoed(t *testing.T) {
+	srv, requests := fakeHTTPTracker(t, func(r *http.Request) map[string]interface{} {
+		return map[string]interface{}{"interval": 60, "tracker id": "abc123", "peers": ""}
+	})
+
+	tl := NewTierList([][]string{{srv.URL + "/announce"}})
+	for i := 0; i < 2; i++ {
+		if _, _, err := tl.Announce(context.Background(), testAnnounceRequest()); err != nil {
+			t.Fatalf("announce %d: %v", i, err)
+		}
+	}
+
+	if got := (*requests)[0].URL.Query().Get("trackerid"); got != "" {
+		t.Errorf("first announce sent trackerid %q", got)
+	}
+	if got := (*requests)[1].URL.Query().Get("trackerid"); got != "abc123" {
+		t.Errorf("second announce trackerid = %q, want abc123", got)
+	}
+}
@@ -0,0 +1,219 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	NotContacted State = iota
+	Working
+	Failing
+)
+
+func (s State) String() string {
+	switch s {
+	case Working:
+		return "working"
+	case Failing:
+		return "failing"
+	}
+	return "not contacted"
+}
+
+// retry delays for a tracker that keeps failing, doubled per failure
+const (
+	minRetryDelay = 30 * time.Second
+	maxRetryDelay = 30 * time.Minute
+)
+
+// announceTimeout bounds one tracker's announce so a dead tracker can't hold
+// up the rest of the list, the UDP backoff alone would wait for hours
+const announceTimeout = time.Minute
+
+// TrackerStatus is a snapshot of one tracker, safe to hand to the rest of the client.
+type TrackerStatus struct {
+	URL          string
+	Tier         int
+	State        State
+	LastError    error
+	LastAnnounce time.Time
+	NextAnnounce time.Time
+	Seeders      int
+	Leechers     int
+}
+
+type trackerEntry struct {
+	status    TrackerStatus
+	tracker   Tracker
+	trackerID string
+	failures  int
+}
+
+// TierList implements the BEP 12 announce-list rules: tiers are tried in
+// order, the trackers of a tier are shuffled once and a tracker that answers
+// is moved to the front of its tier so it is asked first next time.
+type TierList struct {
+	mu    sync.Mutex
+	tiers [][]*trackerEntry
+}
+
+func NewTierList(tiers [][]string) *TierList {
+	tl := &TierList{}
+	seen := make(map[string]bool)
+	for _, urls := range tiers {
+		var tier []*trackerEntry
+		for _, u := range urls {
+			if u == "" || seen[u] {
+				continue
+			}
+			seen[u] = true
+			tier = append(tier, &trackerEntry{status: TrackerStatus{URL: u, Tier: len(tl.tiers)}})
+		}
+		if len(tier) == 0 {
+			continue
+		}
+		rand.Shuffle(len(tier), func(i, j int) {
+			tier[i], tier[j] = tier[j], tier[i]
+		})
+		tl.tiers = append(tl.tiers, tier)
+	}
+	return tl
+}
+
+// Announce walks the tiers in order and returns the first successful
+// response along with the url of the tracker that gave it.
+func (tl *TierList) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, string, error) {
+	var errs []error
+	for tierIdx := 0; tierIdx < tl.numTiers(); tierIdx++ {
+		for _, entry := range tl.tierSnapshot(tierIdx) {
+			if err := ctx.Err(); err != nil {
+				return nil, "", err
+			}
+
+			resp, err := tl.announceOne(ctx, entry, req)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", entry.status.URL, err))
+				continue
+			}
+			tl.promote(tierIdx, entry)
+			return resp, entry.status.URL, nil
+		}
+	}
+	if len(errs) == 0 {
+		return nil, "", fmt.Errorf("no trackers to announce to")
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+func (tl *TierList) announceOne(ctx context.Context, entry *trackerEntry, req *AnnounceRequest) (*AnnounceResponse, error) {
+	tl.mu.Lock()
+	if entry.tracker == nil {
+		t, err := New(entry.status.URL)
+		if err != nil {
+			tl.recordFailure(entry, err)
+			tl.mu.Unlock()
+			return nil, err
+		}
+		entry.tracker = t
+	}
+	t := entry.tracker
+	trackerReq := *req
+	if trackerReq.TrackerID == "" {
+		trackerReq.TrackerID = entry.trackerID
+	}
+	tl.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, announceTimeout)
+	defer cancel()
+	resp, err := t.Announce(ctx, &trackerReq)
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if err != nil {
+		tl.recordFailure(entry, err)
+		return nil, err
+	}
+
+	now := time.Now()
+	entry.failures = 0
+	if resp.TrackerID != "" {
+		entry.trackerID = resp.TrackerID
+	}
+	entry.status.State = Working
+	entry.status.LastError = nil
+	entry.status.LastAnnounce = now
+	entry.status.NextAnnounce = now.Add(NextInterval(resp))
+	entry.status.Seeders = resp.Complete
+	entry.status.Leechers = resp.Incomplete
+	return resp, nil
+}
+
+// recordFailure must be called with tl.mu held
+func (tl *TierList) recordFailure(entry *trackerEntry, err error) {
+	delay := minRetryDelay << entry.failures
+	if delay > maxRetryDelay || delay <= 0 {
+		delay = maxRetryDelay
+	} else {
+		entry.failures++
+	}
+	entry.status.State = Failing
+	entry.status.LastError = err
+	entry.status.NextAnnounce = time.Now().Add(delay)
+}
+
+// NextInterval is how long to wait before announcing to the same tracker again.
+func NextInterval(resp *AnnounceResponse) time.Duration {
+	interval := resp.Interval
+	if interval < resp.MinInterval {
+		interval = resp.MinInterval
+	}
+	if interval <= 0 {
+		interval = minRetryDelay
+	}
+	return interval
+}
+
+func (tl *TierList) promote(tierIdx int, entry *trackerEntry) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tier := tl.tiers[tierIdx]
+	for i, e := range tier {
+		if e == entry {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = entry
+			return
+		}
+	}
+}
+
+func (tl *TierList) numTiers() int {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return len(tl.tiers)
+}
+
+func (tl *TierList) tierSnapshot(tierIdx int) []*trackerEntry {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return append([]*trackerEntry(nil), tl.tiers[tierIdx]...)
+}
+
+// Status returns the current state of every tracker in announce order.
+func (tl *TierList) Status() []TrackerStatus {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	var out []TrackerStatus
+	for _, tier := range tl.tiers {
+		for _, e := range tier {
+			out = append(out, e.status)
+		}
+	}
+	return out
+}
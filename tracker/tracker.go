@@ -0,0 +1,85 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+	"torrent-client/algorithms"
+)
+
+type Event string
+
+const (
+	EventNone      Event = ""
+	EventStarted   Event = "started"
+	EventCompleted Event = "completed"
+	EventStopped   Event = "stopped"
+)
+
+type AnnounceRequest struct {
+	InfoHash   [20]byte
+	PeerID     string
+	Port       int
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	// NumWant is left out of the request when it is zero so the tracker picks its default
+	NumWant   int
+	TrackerID string
+}
+
+type AnnounceResponse struct {
+	Interval       time.Duration
+	MinInterval    time.Duration
+	TrackerID      string
+	Complete       int
+	Incomplete     int
+	WarningMessage string
+	Peers          map[string]*algorithms.Peer
+}
+
+// FailureError is returned when the tracker answered but refused the
+// request, retrying the same tracker right away won't help.
+type FailureError struct {
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return fmt.Sprintf("tracker failure: %s", e.Reason)
+}
+
+// ScrapeResult holds the swarm counts of one torrent.
+type ScrapeResult struct {
+	InfoHash  [20]byte
+	Seeders   int
+	Completed int
+	Leechers  int
+}
+
+var ErrScrapeUnsupported = errors.New("tracker does not support scrape")
+
+type Tracker interface {
+	Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error)
+	// Scrape returns the counts for the given torrents, batching them into
+	// as few requests as the protocol allows. Torrents the tracker does not
+	// know about are left out of the result.
+	Scrape(ctx context.Context, infoHashes [][20]byte) ([]ScrapeResult, error)
+}
+
+// New picks the tracker implementation from the url scheme.
+func New(announceURL string) (Tracker, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid announce url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return NewHTTPTracker(announceURL), nil
+	case "udp":
+		return NewUDPTracker(announceURL)
+	}
+	return nil, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+}
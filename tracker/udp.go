@@ -0,0 +1,291 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+	"torrent-client/utils"
+)
+
+// BEP 15 constants
+const (
+	udpProtocolID = 0x41727101980
+
+	actionConnect  = 0
+	actionAnnounce = 1
+	actionScrape   = 2
+	actionError    = 3
+
+	// a connection id can be used for one minute after it was received
+	connectionIDLifetime = time.Minute
+	// 15 * 2^8 seconds is the longest wait the spec allows
+	maxUDPRetries = 8
+	// the spec limits a scrape to about 74 info hashes per packet
+	maxScrapeHashes = 74
+	maxUDPPacket    = 2048
+)
+
+type UDPTracker struct {
+	URL string
+	// BaseTimeout and MaxRetries follow the spec by default, the timeout for
+	// attempt n is BaseTimeout * 2^n. That adds up to over two hours, so the
+	// caller's context is what should bound an exchange (TierList does).
+	BaseTimeout time.Duration
+	MaxRetries  int
+
+	host      string
+	key       uint32
+	mu        sync.Mutex
+	connID    uint64
+	connAt    time.Time
+	connValid bool
+}
+
+func NewUDPTracker(announceURL string) (*UDPTracker, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid announce url: %w", err)
+	}
+	if u.Scheme != "udp" || u.Port() == "" {
+		return nil, fmt.Errorf("not a udp tracker url: %s", announceURL)
+	}
+	return &UDPTracker{
+		URL:         announceURL,
+		BaseTimeout: 15 * time.Second,
+		MaxRetries:  maxUDPRetries,
+		host:        u.Host,
+		key:         rand.Uint32(),
+	}, nil
+}
+
+func udpEvent(e Event) uint32 {
+	switch e {
+	case EventCompleted:
+		return 1
+	case EventStarted:
+		return 2
+	case EventStopped:
+		return 3
+	}
+	return 0
+}
+
+func (t *UDPTracker) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error) {
+	numWant := int32(-1)
+	if req.NumWant > 0 {
+		numWant = int32(req.NumWant)
+	}
+
+	resp, err := t.exchange(ctx, actionAnnounce, func(buf []byte) []byte {
+		buf = append(buf, req.InfoHash[:]...)
+		buf = append(buf, peerIDBytes(req.PeerID)...)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(req.Downloaded))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(req.Left))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(req.Uploaded))
+		buf = binary.BigEndian.AppendUint32(buf, udpEvent(req.Event))
+		buf = binary.BigEndian.AppendUint32(buf, 0) // ip, let the tracker use the source address
+		buf = binary.BigEndian.AppendUint32(buf, t.key)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(numWant))
+		return binary.BigEndian.AppendUint16(buf, uint16(req.Port))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 20 {
+		return nil, fmt.Errorf("announce response too short: %d bytes", len(resp))
+	}
+
+	peers, err := utils.ParsePeers(resp[20:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peers: %w", err)
+	}
+	return &AnnounceResponse{
+		Interval:   time.Duration(binary.BigEndian.Uint32(resp[8:12])) * time.Second,
+		Incomplete: int(binary.BigEndian.Uint32(resp[12:16])),
+		Complete:   int(binary.BigEndian.Uint32(resp[16:20])),
+		Peers:      peers,
+	}, nil
+}
+
+// Scrape asks for the swarm counts of any number of torrents, they are sent
+// in batches of 74 which is what fits in one packet.
+func (t *UDPTracker) Scrape(ctx context.Context, infoHashes [][20]byte) ([]ScrapeResult, error) {
+	var results []ScrapeResult
+	for start := 0; start < len(infoHashes); start += maxScrapeHashes {
+		end := min(start+maxScrapeHashes, len(infoHashes))
+		batch, err := t.scrapeBatch(ctx, infoHashes[start:end])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batch...)
+	}
+	return results, nil
+}
+
+func (t *UDPTracker) scrapeBatch(ctx context.Context, infoHashes [][20]byte) ([]ScrapeResult, error) {
+	resp, err := t.exchange(ctx, actionScrape, func(buf []byte) []byte {
+		for _, h := range infoHashes {
+			buf = append(buf, h[:]...)
+		}
+		return buf
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 8+12*len(infoHashes) {
+		return nil, fmt.Errorf("scrape response too short: %d bytes", len(resp))
+	}
+
+	var results []ScrapeResult
+	for i, h := range infoHashes {
+		entry := resp[8+12*i:]
+		r := ScrapeResult{
+			InfoHash:  h,
+			Seeders:   int(binary.BigEndian.Uint32(entry[0:4])),
+			Completed: int(binary.BigEndian.Uint32(entry[4:8])),
+			Leechers:  int(binary.BigEndian.Uint32(entry[8:12])),
+		}
+		// BEP 15 has no way to say a torrent is unknown, trackers send zeros
+		// for it, so an all-zero entry is left out like HTTP trackers do
+		if r.Seeders == 0 && r.Completed == 0 && r.Leechers == 0 {
+			continue
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// exchange sends one request, connecting first whenever the connection id
+// is missing or expired, and retransmits with the spec backoff until a
+// response with our transaction id shows up.
+func (t *UDPTracker) exchange(ctx context.Context, action uint32, body func([]byte) []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", t.host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach tracker %s: %w", t.host, err)
+	}
+	defer conn.Close()
+
+	for n := 0; n <= t.MaxRetries; n++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		timeout := t.BaseTimeout << n
+
+		connID, ok := t.connectionID()
+		if !ok {
+			connID, err = t.connect(ctx, conn, timeout)
+			if isTimeout(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		txID := rand.Uint32()
+		buf := binary.BigEndian.AppendUint64(nil, connID)
+		buf = binary.BigEndian.AppendUint32(buf, action)
+		buf = binary.BigEndian.AppendUint32(buf, txID)
+		buf = body(buf)
+
+		resp, err := t.roundTrip(ctx, conn, buf, action, txID, timeout)
+		if isTimeout(err) {
+			continue
+		}
+		return resp, err
+	}
+	return nil, fmt.Errorf("tracker %s did not respond after %d retries", t.host, t.MaxRetries)
+}
+
+func (t *UDPTracker) connectionID() (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.connValid || time.Since(t.connAt) > connectionIDLifetime {
+		return 0, false
+	}
+	return t.connID, true
+}
+
+func (t *UDPTracker) connect(ctx context.Context, conn net.Conn, timeout time.Duration) (uint64, error) {
+	txID := rand.Uint32()
+	buf := binary.BigEndian.AppendUint64(nil, udpProtocolID)
+	buf = binary.BigEndian.AppendUint32(buf, actionConnect)
+	buf = binary.BigEndian.AppendUint32(buf, txID)
+
+	resp, err := t.roundTrip(ctx, conn, buf, actionConnect, txID, timeout)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) < 16 {
+		return 0, fmt.Errorf("connect response too short: %d bytes", len(resp))
+	}
+
+	connID := binary.BigEndian.Uint64(resp[8:16])
+	t.mu.Lock()
+	t.connID, t.connAt, t.connValid = connID, time.Now(), true
+	t.mu.Unlock()
+	return connID, nil
+}
+
+// roundTrip writes the packet and reads until the matching response arrives,
+// packets with another transaction id are stale answers and get dropped.
+func (t *UDPTracker) roundTrip(ctx context.Context, conn net.Conn, packet []byte, action, txID uint32, timeout time.Duration) ([]byte, error) {
+	if _, err := conn.Write(packet); err != nil {
+		return nil, fmt.Errorf("failed to send to tracker: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	// unblock the read as soon as the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	buf := make([]byte, maxUDPPacket)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		if n < 8 || binary.BigEndian.Uint32(buf[4:8]) != txID {
+			continue
+		}
+
+		resp := buf[:n]
+		switch got := binary.BigEndian.Uint32(resp[0:4]); got {
+		case action:
+			return resp, nil
+		case actionError:
+			return nil, &FailureError{Reason: string(resp[8:])}
+		default:
+			return nil, fmt.Errorf("unexpected action %d in tracker response", got)
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// peer ids are 20 bytes on the wire, anything shorter is zero padded
+func peerIDBytes(peerID string) []byte {
+	b := make([]byte, 20)
+	copy(b, peerID)
+	return b
+}
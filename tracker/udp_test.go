@@ -0,0 +1,266 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testConnID = 0x1122334455667788
+
+// fakeUDPTracker is a local stand-in for a BEP 15 tracker. handle gets every
+// packet and returns the packets to send back, nil drops the request.
+type fakeUDPTracker struct {
+	conn net.PacketConn
+
+	mu       sync.Mutex
+	received [][]byte
+}
+
+func newFakeUDPTracker(t *testing.T, handle func(packet []byte) [][]byte) *fakeUDPTracker {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeUDPTracker{conn: conn}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, maxUDPPacket)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packet := append([]byte(nil), buf[:n]...)
+			f.mu.Lock()
+			f.received = append(f.received, packet)
+			f.mu.Unlock()
+			for _, resp := range handle(packet) {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return f
+}
+
+func (f *fakeUDPTracker) url() string {
+	return "udp://" + f.conn.LocalAddr().String() + "/announce"
+}
+
+func (f *fakeUDPTracker) packets() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]byte(nil), f.received...)
+}
+
+func packetAction(packet []byte) uint32 {
+	if binary.BigEndian.Uint64(packet[0:8]) == udpProtocolID {
+		return actionConnect
+	}
+	return binary.BigEndian.Uint32(packet[8:12])
+}
+
+func packetTxID(packet []byte) uint32 {
+	return binary.BigEndian.Uint32(packet[12:16])
+}
+
+func connectResponse(txID uint32) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, actionConnect)
+	buf = binary.BigEndian.AppendUint32(buf, txID)
+	return binary.BigEndian.AppendUint64(buf, testConnID)
+}
+
+func announceResponse(txID uint32, peers []byte) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, actionAnnounce)
+	buf = binary.BigEndian.AppendUint32(buf, txID)
+	buf = binary.BigEndian.AppendUint32(buf, 1800) // interval
+	buf = binary.BigEndian.AppendUint32(buf, 3)    // leechers
+	buf = binary.BigEndian.AppendUint32(buf, 9)    // seeders
+	return append(buf, peers...)
+}
+
+func errorResponse(txID uint32, message string) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, actionError)
+	buf = binary.BigEndian.AppendUint32(buf, txID)
+	return append(buf, message...)
+}
+
+func testUDPTracker(t *testing.T, url string) *UDPTracker {
+	t.Helper()
+	tr, err := NewUDPTracker(url)
+	if err != nil {
+		t.Fatalf("NewUDPTracker: %v", err)
+	}
+	tr.BaseTimeout = 50 * time.Millisecond
+	tr.MaxRetries = 2
+	return tr
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	peers := []byte{10, 0, 0, 1, 0x1a, 0xe1}
+	f := newFakeUDPTracker(t, func(packet []byte) [][]byte {
+		switch packetAction(packet) {
+		case actionConnect:
+			return [][]byte{connectResponse(packetTxID(packet))}
+		case actionAnnounce:
+			return [][]byte{announceResponse(packetTxID(packet), peers)}
+		}
+		return nil
+	})
+
+	tr := testUDPTracker(t, f.url())
+	for i := 0; i < 2; i++ {
+		resp, err := tr.Announce(context.Background(), testAnnounceRequest())
+		if err != nil {
+			t.Fatalf("announce %d: %v", i, err)
+		}
+		if resp.Interval != 30*time.Minute || resp.Incomplete != 3 || resp.Complete != 9 {
+			t.Errorf("response = %+v", resp)
+		}
+		if _, ok := resp.Peers["10.0.0.1:6881"]; !ok || len(resp.Peers) != 1 {
+			t.Errorf("peers = %v", resp.Peers)
+		}
+	}
+
+	// the connection id is reused while it is fresh
+	packets := f.packets()
+	if len(packets) != 3 {
+		t.Fatalf("tracker got %d packets, want connect and two announces", len(packets))
+	}
+	announce := packets[1]
+	if got := binary.BigEndian.Uint64(announce[0:8]); got != testConnID {
+		t.Errorf("announce connection id = %x, want %x", got, uint64(testConnID))
+	}
+	if len(announce) != 98 {
+		t.Fatalf("announce is %d bytes, want 98", len(announce))
+	}
+	if got := binary.BigEndian.Uint32(announce[80:84]); got != 2 {
+		t.Errorf("event = %d, want 2 (started)", got)
+	}
+	if got := binary.BigEndian.Uint16(announce[96:98]); got != 6881 {
+		t.Errorf("port = %d, want 6881", got)
+	}
+}
+
+func TestUDPIgnoresOtherTransactionIDs(t *testing.T) {
+	f := newFakeUDPTracker(t, func(packet []byte) [][]byte {
+		txID := packetTxID(packet)
+		switch packetAction(packet) {
+		case actionConnect:
+			return [][]byte{connectResponse(txID + 1), connectResponse(txID)}
+		case actionAnnounce:
+			return [][]byte{errorResponse(txID+1, "stale"), announceResponse(txID, nil)}
+		}
+		return nil
+	})
+
+	resp, err := testUDPTracker(t, f.url()).Announce(context.Background(), testAnnounceRequest())
+	if err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+	if resp.Complete != 9 {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestUDPErrorAction(t *testing.T) {
+	f := newFakeUDPTracker(t, func(packet []byte) [][]byte {
+		switch packetAction(packet) {
+		case actionConnect:
+			return [][]byte{connectResponse(packetTxID(packet))}
+		case actionAnnounce:
+			return [][]byte{errorResponse(packetTxID(packet), "unregistered torrent")}
+		}
+		return nil
+	})
+
+	_, err := testUDPTracker(t, f.url()).Announce(context.Background(), testAnnounceRequest())
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Reason != "unregistered torrent" {
+		t.Fatalf("err = %v, want the tracker's failure", err)
+	}
+}
+
+func TestUDPRetransmitsAfterTimeout(t *testing.T) {
+	var mu sync.Mutex
+	dropped := false
+	f := newFakeUDPTracker(t, func(packet []byte) [][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		if !dropped {
+			dropped = true
+			return nil
+		}
+		switch packetAction(packet) {
+		case actionConnect:
+			return [][]byte{connectResponse(packetTxID(packet))}
+		case actionAnnounce:
+			return [][]byte{announceResponse(packetTxID(packet), nil)}
+		}
+		return nil
+	})
+
+	if _, err := testUDPTracker(t, f.url()).Announce(context.Background(), testAnnounceRequest()); err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+	if n := len(f.packets()); n != 3 {
+		t.Errorf("tracker got %d packets, want a retransmitted connect and an announce", n)
+	}
+}
+
+func TestUDPGivesUpOnSilentTracker(t *testing.T) {
+	f := newFakeUDPTracker(t, func(packet []byte) [][]byte { return nil })
+
+	tr := testUDPTracker(t, f.url())
+	start := time.Now()
+	if _, err := tr.Announce(context.Background(), testAnnounceRequest()); err == nil {
+		t.Fatal("announce to a silent tracker succeeded")
+	}
+	// 50ms + 100ms + 200ms
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("gave up after %v", elapsed)
+	}
+	if n := len(f.packets()); n != tr.MaxRetries+1 {
+		t.Errorf("tracker got %d connects, want %d", n, tr.MaxRetries+1)
+	}
+}
+
+func TestUDPScrapeLeavesOutUnknownTorrents(t *testing.T) {
+	known := [20]byte{1}
+	unknown := [20]byte{2}
+	f := newFakeUDPTracker(t, func(packet []byte) [][]byte {
+		switch packetAction(packet) {
+		case actionConnect:
+			return [][]byte{connectResponse(packetTxID(packet))}
+		case actionScrape:
+			buf := binary.BigEndian.AppendUint32(nil, actionScrape)
+			buf = binary.BigEndian.AppendUint32(buf, packetTxID(packet))
+			for i := 16; i+20 <= len(packet); i += 20 {
+				if [20]byte(packet[i:i+20]) == known {
+					buf = binary.BigEndian.AppendUint32(buf, 9) // seeders
+					buf = binary.BigEndian.AppendUint32(buf, 4) // completed
+					buf = binary.BigEndian.AppendUint32(buf, 3) // leechers
+				} else {
+					buf = append(buf, make([]byte, 12)...)
+				}
+			}
+			return [][]byte{buf}
+		}
+		return nil
+	})
+
+	results, err := testUDPTracker(t, f.url()).Scrape(context.Background(), [][20]byte{unknown, known})
+	if err != nil {
+		t.Fatalf("Scrape: %v", err)
+	}
+	want := []ScrapeResult{{InfoHash: known, Seeders: 9, Completed: 4, Leechers: 3}}
+	if len(results) != 1 || results[0] != want[0] {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+}
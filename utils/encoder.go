@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// RawMessage is an already encoded value, it is written out untouched and
+// when decoding it keeps the original bytes of the value.
+type RawMessage []byte
+
+type Encoder struct {
+	w   io.Writer
+	err error
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes v as bencode. Supported values are the integer kinds,
+// strings, []byte and byte arrays, slices, maps with string keys, structs
+// (see MarshalBencode for the tags) and pointers or interfaces holding one
+// of those.
+func (e *Encoder) Encode(v interface{}) error {
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	return e.err
+}
+
+func MarshalBencode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *Encoder) write(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *Encoder) writeString(s string) {
+	e.write([]byte(strconv.Itoa(len(s)) + ":" + s))
+}
+
+func (e *Encoder) writeBytes(b []byte) {
+	e.write([]byte(strconv.Itoa(len(b)) + ":"))
+	e.write(b)
+}
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+func (e *Encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		return fmt.Errorf("bencode: cannot encode nil value")
+	}
+	if v.Type() == rawMessageType {
+		if v.Len() == 0 {
+			return fmt.Errorf("bencode: empty RawMessage")
+		}
+		e.write(v.Bytes())
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.write([]byte("i" + strconv.FormatInt(v.Int(), 10) + "e"))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		e.write([]byte("i" + strconv.FormatUint(v.Uint(), 10) + "e"))
+	case reflect.Bool:
+		if v.Bool() {
+			e.write([]byte("i1e"))
+		} else {
+			e.write([]byte("i0e"))
+		}
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBytes(v.Bytes())
+			return nil
+		}
+		return e.encodeList(v)
+	case reflect.Array:
+		// fixed size byte arrays are info hashes and peer ids, those are strings on the wire
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.writeBytes(b)
+			return nil
+		}
+		return e.encodeList(v)
+	case reflect.Map:
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("bencode: cannot encode nil %s", v.Type())
+		}
+		return e.encode(v.Elem())
+	default:
+		return fmt.Errorf("bencode: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *Encoder) encodeList(v reflect.Value) error {
+	e.write([]byte("l"))
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	e.write([]byte("e"))
+	return nil
+}
+
+func (e *Encoder) encodeMap(v reflect.Value) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("bencode: map key must be a string, got %s", v.Type().Key())
+	}
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	// dict keys have to be sorted as raw strings
+	sort.Strings(keys)
+
+	e.write([]byte("d"))
+	for _, k := range keys {
+		e.writeString(k)
+		if err := e.encode(v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))); err != nil {
+			return err
+		}
+	}
+	e.write([]byte("e"))
+	return nil
+}
+
+func (e *Encoder) encodeStruct(v reflect.Value) error {
+	fields := structFields(v.Type())
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].key < fields[j].key
+	})
+
+	e.write([]byte("d"))
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		e.writeString(f.key)
+		if err := e.encode(fv); err != nil {
+			return fmt.Errorf("bencode: field %s: %w", f.key, err)
+		}
+	}
+	e.write([]byte("e"))
+	return nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
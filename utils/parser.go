@@ -1,33 +1,105 @@
 package utils
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"math/rand"
 	"strconv"
-	"unicode"
 )
 
+const (
+	DefaultMaxDepth        = 64
+	DefaultMaxStringLength = 16 << 20
+)
+
+var (
+	ErrUnexpectedEOF       = errors.New("unexpected end of input")
+	ErrUnexpectedCharacter = errors.New("unexpected character")
+	ErrInvalidInteger      = errors.New("invalid integer")
+	ErrNonCanonicalInteger = errors.New("non-canonical integer")
+	ErrInvalidStringLength = errors.New("invalid string length")
+	ErrStringTooLong       = errors.New("string exceeds maximum length")
+	ErrMaxDepthExceeded    = errors.New("maximum nesting depth exceeded")
+	ErrTrailingData        = errors.New("trailing data after value")
+)
+
+// DecodeError tells where in the input the decoder gave up, Err is one of
+// the Err* values above so callers can match it with errors.Is.
+type DecodeError struct {
+	Offset int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("bencode: %v at offset %d", e.Err, e.Offset)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Parser decodes bencoded data into int64, string, []interface{} and
+// map[string]interface{} values. The data usually comes from trackers and
+// peers so nothing is trusted, every read is bounds checked and the limits
+// can be tightened before calling Decode.
 type Parser struct {
-	data []byte
-	pos  int
+	data            []byte
+	pos             int
+	depth           int
+	MaxDepth        int
+	MaxStringLength int
 }
 
 func NewParser(input []byte) *Parser {
-	return &Parser{data: input, pos: 0}
+	return &Parser{
+		data:            input,
+		pos:             0,
+		MaxDepth:        DefaultMaxDepth,
+		MaxStringLength: DefaultMaxStringLength,
+	}
 }
 
-func (p *Parser) peek() byte {
-	return p.data[p.pos]
+// Decode parses a single value from data and fails if anything follows it.
+func Decode(data []byte) (interface{}, error) {
+	p := NewParser(data)
+	v, err := p.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.data) {
+		return nil, p.errorf(ErrTrailingData)
+	}
+	return v, nil
 }
 
-func (p *Parser) next() byte {
-	ch := p.data[p.pos]
-	p.pos++
-	return ch
+// Decode parses the next value, anything after it is left for the caller
+// which is what ut_metadata needs since the piece data follows the dict.
+func (p *Parser) Decode() (interface{}, error) {
+	return p.parse()
+}
+
+// Offset is the position of the first byte not consumed yet.
+func (p *Parser) Offset() int {
+	return p.pos
+}
+
+func (p *Parser) errorf(err error) error {
+	return &DecodeError{Offset: p.pos, Err: err}
 }
 
-func (p *Parser) parse() interface{} {
-	ch := p.peek()
+func (p *Parser) peek() (byte, error) {
+	if p.pos >= len(p.data) {
+		return 0, p.errorf(ErrUnexpectedEOF)
+	}
+	return p.data[p.pos], nil
+}
+
+func (p *Parser) parse() (interface{}, error) {
+	ch, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
 	switch ch {
 	case 'i':
 		return p.parseInt()
@@ -36,73 +108,199 @@ func (p *Parser) parse() interface{} {
 	case 'd':
 		return p.parseDict()
 	default:
-		if unicode.IsDigit(rune(ch)) {
+		if ch >= '0' && ch <= '9' {
 			return p.parseString()
 		}
-		panic(fmt.Sprintf("unexpected character: %c", ch))
+		return nil, p.errorf(ErrUnexpectedCharacter)
 	}
 }
 
-func (p *Parser) parseInt() int {
-	p.next()
-	start := p.pos
-
-	for p.data[p.pos] != 'e' {
-		p.pos++
+// readUntil returns the bytes up to the delimiter and moves past it.
+func (p *Parser) readUntil(delim byte) ([]byte, error) {
+	end := bytes.IndexByte(p.data[p.pos:], delim)
+	if end < 0 {
+		p.pos = len(p.data)
+		return nil, p.errorf(ErrUnexpectedEOF)
 	}
-	valStr := string(p.data[start:p.pos])
+	raw := p.data[p.pos : p.pos+end]
+	p.pos += end + 1
+	return raw, nil
+}
 
-	length, err := strconv.Atoi(valStr)
+func (p *Parser) parseInt() (int64, error) {
+	p.pos++ // skip 'i'
+	start := p.pos
+	raw, err := p.readUntil('e')
 	if err != nil {
-		panic("invalid integer: " + valStr)
+		return 0, err
 	}
-	p.pos++
-	return length
+	if !isCanonicalInt(raw) {
+		p.pos = start
+		return 0, p.errorf(ErrNonCanonicalInteger)
+	}
+	val, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		p.pos = start
+		return 0, p.errorf(ErrInvalidInteger)
+	}
+	return val, nil
 }
 
-func (p *Parser) parseString() string {
-	start := p.pos
-	for p.data[p.pos] != ':' {
-		p.pos++
+// i-0e and leading zeros are valid for strconv but not for bencode
+func isCanonicalInt(raw []byte) bool {
+	digits := raw
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+		if len(digits) > 0 && digits[0] == '0' {
+			return false
+		}
+	}
+	if len(digits) == 0 {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
-	lenStr := string(p.data[start:p.pos])
-	length, err := strconv.Atoi(lenStr)
+	return digits[0] != '0' || len(digits) == 1
+}
+
+func (p *Parser) parseString() (string, error) {
+	raw, err := p.parseBytes()
+	return string(raw), err
+}
+
+func (p *Parser) parseBytes() ([]byte, error) {
+	start := p.pos
+	lenStr, err := p.readUntil(':')
 	if err != nil {
-		panic("invalid string length")
+		return nil, err
+	}
+	length, err := strconv.Atoi(string(lenStr))
+	if err != nil || length < 0 || !isCanonicalInt(lenStr) {
+		p.pos = start
+		return nil, p.errorf(ErrInvalidStringLength)
+	}
+	if length > p.MaxStringLength {
+		p.pos = start
+		return nil, p.errorf(ErrStringTooLong)
+	}
+	if length > len(p.data)-p.pos {
+		return nil, p.errorf(ErrUnexpectedEOF)
 	}
-	p.pos++ // skip ':'
 	// read string content
 	end := p.pos + length
-	str := string(p.data[p.pos:end])
+	raw := p.data[p.pos:end]
 	p.pos = end
-	return str
+	return raw, nil
+}
 
+func (p *Parser) enter() error {
+	p.depth++
+	if p.depth > p.MaxDepth {
+		return p.errorf(ErrMaxDepthExceeded)
+	}
+	return nil
 }
 
-func (p *Parser) parseList() []interface{} {
+func (p *Parser) parseList() ([]interface{}, error) {
 	// like if I talk about the implementation of the list then it is straight forward like we just have to
 	// check that and parse that the other work is done by the parse func
-	p.next()
-	var result []interface{}
-	for p.data[p.pos] != 'e' {
-		result = append(result, p.parse())
+	if err := p.enter(); err != nil {
+		return nil, err
 	}
+	defer func() { p.depth-- }()
 	p.pos++
-	return result
+
+	result := []interface{}{}
+	for {
+		ch, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if ch == 'e' {
+			break
+		}
+		val, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, val)
+	}
+	p.pos++
+	return result, nil
 }
 
-func (p *Parser) parseDict() map[string]interface{} {
-	p.next()
+func (p *Parser) parseDict() (map[string]interface{}, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	p.pos++
 
 	result := make(map[string]interface{})
 	// if i create my own logic then there is some type of mechansim through which we have to distinguish between the key and values
-	for p.peek() != 'e' {
-		key := p.parse().(string)
-		value := p.parse()
+	for {
+		ch, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if ch == 'e' {
+			break
+		}
+		// keys are always strings so anything else is rejected right here
+		if ch < '0' || ch > '9' {
+			return nil, p.errorf(ErrUnexpectedCharacter)
+		}
+		key, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
 		result[key] = value
 	}
 	p.pos++
-	return result
+	return result, nil
+}
+
+// RawDictValue returns the exact encoded bytes of key in the top level dict
+// of data, things like the info hash have to be computed over the original
+// bytes and not over a re-encoded struct
+func RawDictValue(data []byte, key string) ([]byte, error) {
+	p := NewParser(data)
+	ch, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if ch != 'd' {
+		return nil, fmt.Errorf("top level value is not a dict")
+	}
+	p.pos++
+	for {
+		ch, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if ch == 'e' {
+			break
+		}
+		k, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		start := p.pos
+		if _, err := p.parse(); err != nil {
+			return nil, err
+		}
+		if k == key {
+			return data[start:p.pos], nil
+		}
+	}
+	return nil, fmt.Errorf("key %q not found", key)
 }
 
 func GeneratePeerID() string {
@@ -119,7 +317,6 @@ func RandString(n int) string {
 }
 
 func EncodeInfoHash(infoHash [20]byte) string {
-	fmt.Println(infoHash)
 	var encoded string
 	for _, b := range infoHash {
 		encoded += fmt.Sprintf("%%%02X", b) // %XX format
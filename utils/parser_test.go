@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"i0e", int64(0)},
+		{"i-42e", int64(-42)},
+		{"i9223372036854775807e", int64(9223372036854775807)},
+		{"0:", ""},
+		{"4:spam", "spam"},
+		{"le", []interface{}{}},
+		{"l4:spami3ee", []interface{}{"spam", int64(3)}},
+		{"d3:cow3:moo4:spaml1:a1:bee", map[string]interface{}{"cow": "moo", "spam": []interface{}{"a", "b"}}},
+		{strings.Repeat("l", DefaultMaxDepth) + strings.Repeat("e", DefaultMaxDepth), nestedLists(DefaultMaxDepth)},
+	}
+	for _, tt := range tests {
+		got, err := Decode([]byte(tt.input))
+		if err != nil {
+			t.Errorf("Decode(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Decode(%q) = %#v, want %#v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func nestedLists(depth int) interface{} {
+	v := []interface{}{}
+	for i := 1; i < depth; i++ {
+		v = []interface{}{v}
+	}
+	return v
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		err    error
+		offset int
+	}{
+		{"", ErrUnexpectedEOF, 0},
+		{"x", ErrUnexpectedCharacter, 0},
+		{"i-0e", ErrNonCanonicalInteger, 1},
+		{"i03e", ErrNonCanonicalInteger, 1},
+		{"i-03e", ErrNonCanonicalInteger, 1},
+		{"ie", ErrNonCanonicalInteger, 1},
+		{"i1.5e", ErrNonCanonicalInteger, 1},
+		{"i12", ErrUnexpectedEOF, 3},
+		{"i9223372036854775808e", ErrInvalidInteger, 1},
+		{"03:abc", ErrInvalidStringLength, 0},
+		{"99999999999999999999:", ErrInvalidStringLength, 0},
+		{"5:abc", ErrUnexpectedEOF, 2},
+		{"l4:spam", ErrUnexpectedEOF, 7},
+		{"di1ei2ee", ErrUnexpectedCharacter, 1},
+		{"d3:key", ErrUnexpectedEOF, 6},
+		{"i1ei2e", ErrTrailingData, 3},
+		{"4:spamx", ErrTrailingData, 6},
+		{strings.Repeat("l", DefaultMaxDepth+1), ErrMaxDepthExceeded, DefaultMaxDepth},
+		{"l" + strings.Repeat("d1:a", DefaultMaxDepth), ErrMaxDepthExceeded, 1 + 4*(DefaultMaxDepth-1)},
+	}
+	for _, tt := range tests {
+		_, err := Decode([]byte(tt.input))
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || !errors.Is(err, tt.err) || decodeErr.Offset != tt.offset {
+			t.Errorf("Decode(%q) = %v, want %v at offset %d", tt.input, err, tt.err, tt.offset)
+		}
+	}
+}
+
+func TestParserLimits(t *testing.T) {
+	tests := []struct {
+		input     string
+		maxDepth  int
+		maxString int
+		err       error
+	}{
+		{"3:abc", DefaultMaxDepth, 3, nil},
+		{"4:abcd", DefaultMaxDepth, 3, ErrStringTooLong},
+		// the length is checked before the data, a huge claim fails on the limit and not on EOF
+		{"16777217:", DefaultMaxDepth, DefaultMaxStringLength, ErrStringTooLong},
+		{"llee", 2, DefaultMaxStringLength, nil},
+		{"llleee", 2, DefaultMaxStringLength, ErrMaxDepthExceeded},
+	}
+	for _, tt := range tests {
+		p := NewParser([]byte(tt.input))
+		p.MaxDepth = tt.maxDepth
+		p.MaxStringLength = tt.maxString
+		_, err := p.Decode()
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Decode(%q) with limits %d, %d = %v, want %v", tt.input, tt.maxDepth, tt.maxString, err, tt.err)
+		}
+	}
+}
+
+func TestParserLeavesTrailingData(t *testing.T) {
+	// ut_metadata puts the piece right after the dict
+	p := NewParser([]byte("d8:msg_typei1ee" + "piece data"))
+	if _, err := p.Decode(); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if p.Offset() != 15 {
+		t.Errorf("Offset() = %d, want 15", p.Offset())
+	}
+}
@@ -76,3 +76,25 @@ func ParsePeersFromDict(peersDict []interface{}) (map[string]*algorithms.Peer, e
 	}
 	return peers, nil
 }
+
+// ParsePeers6 is ParsePeers for the compact IPv6 format, 16 bytes IP + 2 bytes port.
+func ParsePeers6(peersBin []byte) (map[string]*algorithms.Peer, error) {
+	const peerSize = 18
+	if len(peersBin)%peerSize != 0 {
+		return nil, fmt.Errorf("malformed peers6 binary data")
+	}
+
+	peers := make(map[string]*algorithms.Peer, len(peersBin)/peerSize)
+	for i := 0; i < len(peersBin); i += peerSize {
+		ip := net.IP(append([]byte(nil), peersBin[i:i+16]...))
+		port := binary.BigEndian.Uint16(peersBin[i+16 : i+18])
+		key := fmt.Sprintf("%s:%d", ip, port)
+		peers[key] = &algorithms.Peer{
+			IP:              ip,
+			PORT:            port,
+			Choked:          true,
+			LastCheckedTime: time.Now(),
+		}
+	}
+	return peers, nil
+}
@@ -0,0 +1,292 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type structField struct {
+	key       string
+	index     int
+	omitEmpty bool
+}
+
+// structFields reads the `bencode:"key,omitempty"` tags, a field tagged
+// "-" is skipped and an untagged field uses its Go name as the key.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := f.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{
+			key:       name,
+			index:     i,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	return fields
+}
+
+// UnmarshalTypeError means a value did not fit the Go type it was decoded into.
+type UnmarshalTypeError struct {
+	Value  string
+	Type   reflect.Type
+	Offset int
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("bencode: cannot decode %s into %s at offset %d", e.Value, e.Type, e.Offset)
+}
+
+// UnmarshalBencode decodes data into the value pointed to by v. Dict keys
+// that have no matching field are skipped, a RawMessage field receives the
+// exact encoded bytes of its value.
+func UnmarshalBencode(data []byte, v interface{}) error {
+	p := NewParser(data)
+	if err := p.Unmarshal(v); err != nil {
+		return err
+	}
+	if p.pos != len(p.data) {
+		return p.errorf(ErrTrailingData)
+	}
+	return nil
+}
+
+// Unmarshal decodes the next value into v and leaves the rest of the input.
+func (p *Parser) Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("bencode: Unmarshal needs a non-nil pointer, got %T", v)
+	}
+	return p.unmarshal(rv.Elem())
+}
+
+func (p *Parser) typeError(value string, t reflect.Type) error {
+	return &UnmarshalTypeError{Value: value, Type: t, Offset: p.pos}
+}
+
+func (p *Parser) unmarshal(v reflect.Value) error {
+	ch, err := p.peek()
+	if err != nil {
+		return err
+	}
+
+	if v.Type() == rawMessageType {
+		start := p.pos
+		if _, err := p.parse(); err != nil {
+			return err
+		}
+		v.SetBytes(append(RawMessage(nil), p.data[start:p.pos]...))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return p.unmarshal(v.Elem())
+	case reflect.Interface:
+		val, err := p.parse()
+		if err != nil {
+			return err
+		}
+		if val != nil && !reflect.TypeOf(val).AssignableTo(v.Type()) {
+			return p.typeError(reflect.TypeOf(val).String(), v.Type())
+		}
+		v.Set(reflect.ValueOf(val))
+		return nil
+	}
+
+	switch {
+	case ch == 'i':
+		return p.unmarshalInt(v)
+	case ch == 'l':
+		return p.unmarshalList(v)
+	case ch == 'd':
+		return p.unmarshalDict(v)
+	case ch >= '0' && ch <= '9':
+		return p.unmarshalString(v)
+	}
+	return p.errorf(ErrUnexpectedCharacter)
+}
+
+func (p *Parser) unmarshalInt(v reflect.Value) error {
+	start := p.pos
+	n, err := p.parseInt()
+	if err != nil {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(n) {
+			p.pos = start
+			return p.typeError("integer "+fmt.Sprint(n), v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			p.pos = start
+			return p.typeError("integer "+fmt.Sprint(n), v.Type())
+		}
+		v.SetUint(uint64(n))
+	case reflect.Bool:
+		v.SetBool(n != 0)
+	default:
+		p.pos = start
+		return p.typeError("integer", v.Type())
+	}
+	return nil
+}
+
+func (p *Parser) unmarshalString(v reflect.Value) error {
+	start := p.pos
+	raw, err := p.parseBytes()
+	if err != nil {
+		return err
+	}
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(raw))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(append([]byte(nil), raw...))
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if len(raw) != v.Len() {
+			p.pos = start
+			return p.typeError(fmt.Sprintf("string of length %d", len(raw)), v.Type())
+		}
+		reflect.Copy(v, reflect.ValueOf(raw))
+	default:
+		p.pos = start
+		return p.typeError("string", v.Type())
+	}
+	return nil
+}
+
+func (p *Parser) unmarshalList(v reflect.Value) error {
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return p.typeError("list", v.Type())
+	}
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer func() { p.depth-- }()
+	p.pos++
+
+	if v.Kind() == reflect.Slice {
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	}
+	for i := 0; ; i++ {
+		ch, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if ch == 'e' {
+			break
+		}
+		if v.Kind() == reflect.Array {
+			if i >= v.Len() {
+				return p.typeError("list longer than array", v.Type())
+			}
+			if err := p.unmarshal(v.Index(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := p.unmarshal(elem); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, elem))
+	}
+	p.pos++
+	return nil
+}
+
+func (p *Parser) unmarshalDict(v reflect.Value) error {
+	var fields []structField
+	switch v.Kind() {
+	case reflect.Struct:
+		fields = structFields(v.Type())
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return p.typeError("dict", v.Type())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	default:
+		return p.typeError("dict", v.Type())
+	}
+
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer func() { p.depth-- }()
+	p.pos++
+
+	for {
+		ch, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if ch == 'e' {
+			break
+		}
+		if ch < '0' || ch > '9' {
+			return p.errorf(ErrUnexpectedCharacter)
+		}
+		key, err := p.parseString()
+		if err != nil {
+			return err
+		}
+
+		if v.Kind() == reflect.Map {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := p.unmarshal(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+			continue
+		}
+
+		field, ok := findField(fields, key)
+		if !ok {
+			// unknown keys are fine, just skip over the value
+			if _, err := p.parse(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := p.unmarshal(v.Field(field.index)); err != nil {
+			return err
+		}
+	}
+	p.pos++
+	return nil
+}
+
+func findField(fields []structField, key string) (structField, bool) {
+	for _, f := range fields {
+		if f.key == key {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.key, key) {
+			return f, true
+		}
+	}
+	return structField{}, false
+}